@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultAutoscaleInterval   time.Duration = 5 * time.Second  // Default sampling interval.
+	DefaultAutoscaleCooldown   time.Duration = 30 * time.Second // Default delay between scalings.
+	DefaultAutoscaleLatency    time.Duration = 10 * time.Second // Default backlog latency target.
+	DefaultAutoscaleHysteresis float64       = 0.5              // Default scale down ratio.
+)
+
+// Autoscaler adjusts the workers count of a running pool, it samples
+// the ready backlog and the average job duration and sizes the pool
+// so that the backlog is processed within the target latency.
+type Autoscaler struct {
+	Min        int           // Minimum workers count.
+	Max        int           // Maximum workers count.
+	Interval   time.Duration // Sampling interval.
+	Cooldown   time.Duration // Minimum delay between scalings.
+	Latency    time.Duration // Backlog latency target.
+	Hysteresis float64       // Scale down once latency falls below Latency * Hysteresis.
+	Logger     *slog.Logger  // Scalings logger, the pool logger if nil.
+
+	mu    sync.Mutex
+	total time.Duration // Jobs duration since the last sample.
+	count int           // Jobs count since the last sample.
+	avg   time.Duration // Average job duration.
+}
+
+// NewAutoscaler returns an autoscaler instance keeping
+// the workers count between min and max.
+func NewAutoscaler(min, max int, opts ...func(*Autoscaler)) (*Autoscaler, error) {
+	a := &Autoscaler{
+		Min:        min,
+		Max:        max,
+		Interval:   DefaultAutoscaleInterval,
+		Cooldown:   DefaultAutoscaleCooldown,
+		Latency:    DefaultAutoscaleLatency,
+		Hysteresis: DefaultAutoscaleHysteresis,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.Min < 1 || a.Min > a.Max {
+		return nil, NewErrorFmt("bad workers range: %v-%v", a.Min, a.Max)
+	}
+
+	if a.Interval <= 0 || a.Latency <= 0 {
+		return nil, NewErrorFmt("bad interval or latency: %v, %v", a.Interval, a.Latency)
+	}
+
+	return a, nil
+}
+
+// Desired returns the workers count needed to process the ready
+// backlog in time, the current count is kept within the hysteresis
+// band. A zero average means no job duration was sampled yet.
+func (a *Autoscaler) Desired(workers int, ready uint64, avg time.Duration) int {
+	n := workers
+
+	switch {
+	case avg == 0:
+		// Grow one worker at a time until jobs are sampled.
+		if ready > uint64(workers) {
+			n = workers + 1
+		}
+	default:
+		work := float64(ready) * float64(avg)
+		need := int(math.Ceil(work / float64(a.Latency)))
+		latency := work / float64(max(workers, 1))
+
+		if latency > float64(a.Latency) || latency < float64(a.Latency)*a.Hysteresis {
+			n = need
+		}
+	}
+
+	return min(max(n, a.Min), a.Max)
+}
+
+// Run samples the pool queue and scales the pool until ctx is done.
+func (a *Autoscaler) Run(ctx context.Context, p *Pool) {
+	logger := loggerFromContext(ctx, a.Logger)
+
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ready, _, err := p.queue.Size()
+		if err != nil {
+			logger.Error("Autoscale failure", "error", err)
+			continue
+		}
+
+		workers := p.workers()
+		n := a.Desired(workers, ready, a.sample())
+		if n == workers || time.Since(last) < a.Cooldown {
+			continue
+		}
+
+		logger.Info("Autoscaling", "from", workers, "to", n, "ready", ready)
+		if err := p.Scale(n); err != nil {
+			logger.Error("Autoscale failure", "error", err)
+			continue
+		}
+		last = time.Now()
+	}
+}
+
+// record adds a job duration to the current sample.
+func (a *Autoscaler) record(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.total += d
+	a.count++
+}
+
+// sample returns the average job duration, the previous
+// average is kept if no job completed since the last sample.
+func (a *Autoscaler) sample() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.count > 0 {
+		a.avg = a.total / time.Duration(a.count)
+		a.total, a.count = 0, 0
+	}
+	return a.avg
+}
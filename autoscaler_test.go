@@ -0,0 +1,113 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+// napJob represents a job which sleeps for D milliseconds.
+type napJob struct {
+	D int
+}
+
+func (j *napJob) Make(args *worker.Args) (worker.Job, error) {
+	return &napJob{D: args.Get("D").MustInt(0)}, nil
+}
+
+func (j *napJob) Run() error {
+	time.Sleep(time.Duration(j.D) * time.Millisecond)
+	return nil
+}
+
+func TestAutoscalerDesired(t *testing.T) {
+	a, err := worker.NewAutoscaler(2, 20, func(a *worker.Autoscaler) {
+		a.Latency = 10 * time.Second
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		workers int
+		ready   uint64
+		avg     time.Duration
+		want    int
+	}{
+		{5, 0, 0, 5},                      // No samples, no backlog.
+		{5, 10, 0, 6},                     // No samples, grow by one.
+		{5, 100, time.Second, 10},         // 100s of work, 10s target.
+		{5, 1000, time.Second, 20},        // Bounded by max.
+		{10, 70, time.Second, 10},         // 7s latency, within the band.
+		{10, 30, time.Second, 3},          // 3s latency, below the band.
+		{10, 0, time.Second, 2},           // Bounded by min.
+		{1, 5, 100 * time.Millisecond, 2}, // Min wins over need.
+	}
+
+	for _, tt := range tests {
+		if got := a.Desired(tt.workers, tt.ready, tt.avg); got != tt.want {
+			t.Errorf("Desired(%v, %v, %v) = %v; want %v", tt.workers, tt.ready, tt.avg, got, tt.want)
+		}
+	}
+}
+
+func TestNewAutoscaler(t *testing.T) {
+	for _, r := range [][2]int{{0, 4}, {-1, 4}, {5, 4}} {
+		if _, err := worker.NewAutoscaler(r[0], r[1]); err == nil {
+			t.Errorf("%v-%v: expecting an error", r[0], r[1])
+		}
+	}
+
+	if _, err := worker.NewAutoscaler(1, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPoolAutoscale(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, err := worker.NewAutoscaler(1, 4, func(a *worker.Autoscaler) {
+		a.Interval = 20 * time.Millisecond
+		a.Cooldown = 0
+		a.Latency = 50 * time.Millisecond
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetWorkers(1),
+		worker.SetSignalPolicy(worker.NoSignals),
+		worker.SetAutoscaler(a),
+	)
+	pool.Add(&napJob{})
+
+	scaled := make(chan int, 10)
+	pool.Observe(worker.ObserverFunc(func(e worker.Event) {
+		if e.Kind == worker.Scaled {
+			scaled <- e.Workers
+		}
+	}))
+
+	for i := 0; i < 20; i++ {
+		if err := q.Put(&napJob{D: 20}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go pool.Run(ctx)
+
+	select {
+	case n := <-scaled:
+		if n < 2 || n > 4 {
+			t.Errorf("expecting 2 to 4 workers, got %v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expecting pool to scale up")
+	}
+}
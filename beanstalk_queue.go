@@ -1,7 +1,7 @@
 package worker
 
 import (
-	"encoding/json"
+	"math"
 	"net"
 	"strconv"
 	"time"
@@ -15,6 +15,8 @@ const (
 	BeanstalkTube = "default"   // Beanstalk default queue.
 	BeanstalkPrio = 100         // Beanstalk default job priority.
 
+	BeanstalkFailedSuffix = "-failed" // Beanstalk failed jobs tube suffix.
+
 	beanstalkReadyKey  = "current-jobs-ready"
 	beanstalkFailedKey = "current-jobs-buried"
 )
@@ -26,46 +28,63 @@ var (
 
 // beanstalkMessage represents data returned by Reserve.
 type beanstalkMessage struct {
-	ID        uint64 // Message ID.
+	id        uint64 // Message ID.
 	*Envelope        // Holds parsed json.
 }
 
 // newBeanstalkMessage returns an instance of beanstalkMessage.
-func newBeanstalkMessage(id uint64, payload []byte) (*beanstalkMessage, error) {
-	base, err := NewEnvelope(payload)
+func newBeanstalkMessage(c Codec, id uint64, payload []byte) (*beanstalkMessage, error) {
+	base, err := decodeEnvelope(c, payload)
 	if err != nil {
 		return nil, err
 	}
 
 	env := &beanstalkMessage{
-		ID:       id,
+		id:       id,
 		Envelope: base,
 	}
 
 	return env, nil
 }
 
-// BeanstalkQueue represents a Beanstalk queue.
+// ID returns the backend ID of the message.
+func (m *beanstalkMessage) ID() string { return strconv.FormatUint(m.id, 10) }
+
+// BeanstalkQueue represents a Beanstalk queue, failed jobs
+// are moved to a separate tube (see FailedName) along with
+// the rejection details.
+//
+// Beanstalk jobs are immutable: Defer releases the job natively
+// keeping its body, while Release and Reject put the updated body
+// as a new job and then delete the reserved one. A failed delete
+// duplicates the job, the jobs are delivered at least once.
 type BeanstalkQueue struct {
-	Host string        // Beanstalk host.
-	Port string        // Beanstalk port.
-	Name string        // Beanstalk tube name.
-	Prio uint32        // Beanstalk priority.
-	TTR  time.Duration // Beanstalk time to run.
+	Host       string        // Beanstalk host.
+	Port       string        // Beanstalk port.
+	Name       string        // Beanstalk tube name.
+	FailedName string        // Beanstalk failed jobs tube name.
+	Prio       uint32        // Beanstalk priority.
+	TTR        time.Duration // Beanstalk time to run.
+	Locker     Locker        // Unique jobs lock store.
+	Codec      Codec         // Payloads codec.
 
 	conn *beanstalk.Conn
 	tube *beanstalk.Tube
 	tset *beanstalk.TubeSet
+	dead *beanstalk.Tube
+	dset *beanstalk.TubeSet
 }
 
 // NewBeanstalkQueue returns a queue instance using custom options.
 func NewBeanstalkQueue(opts ...func(*BeanstalkQueue)) (Queue, error) {
 	q := &BeanstalkQueue{
-		Host: BeanstalkHost,
-		Port: BeanstalkPort,
-		Name: BeanstalkTube,
-		Prio: BeanstalkPrio,
-		TTR:  BeanstalkTTR,
+		Host:   BeanstalkHost,
+		Port:   BeanstalkPort,
+		Name:   BeanstalkTube,
+		Prio:   BeanstalkPrio,
+		TTR:    BeanstalkTTR,
+		Locker: NewMemoryLocker(),
+		Codec:  JSONCodec,
 	}
 
 	// Apply options.
@@ -73,6 +92,10 @@ func NewBeanstalkQueue(opts ...func(*BeanstalkQueue)) (Queue, error) {
 		opt(q)
 	}
 
+	if q.FailedName == "" {
+		q.FailedName = q.Name + BeanstalkFailedSuffix
+	}
+
 	addr := net.JoinHostPort(q.Host, q.Port)
 
 	conn, err := beanstalk.Dial("tcp", addr)
@@ -87,52 +110,56 @@ func NewBeanstalkQueue(opts ...func(*BeanstalkQueue)) (Queue, error) {
 	}
 	q.tube = tube
 	q.tset = beanstalk.NewTubeSet(conn, q.Name)
+	q.dead = &beanstalk.Tube{
+		Conn: q.conn,
+		Name: q.FailedName,
+	}
+	q.dset = beanstalk.NewTubeSet(conn, q.FailedName)
 
 	return q, nil
 }
 
 // Put puts the job in the queue.
 func (q *BeanstalkQueue) Put(j Job) error {
-	prio := q.Prio
-
-	typ, err := StructType(j)
-	if err != nil {
-		return err
-	}
+	return q.PutIn(j, 0)
+}
 
-	job := &Payload{
-		Type: typ,
-		Args: j,
-	}
+// PutIn puts the job in the queue, the job becomes
+// ready after the delay elapses.
+func (q *BeanstalkQueue) PutIn(j Job, delay time.Duration) error {
+	prio := q.Prio
 
-	body, err := json.Marshal(job)
-	if err != nil {
-		return err
+	if v, ok := unwrapJob(j).(Priority); ok {
+		prio = v.Prio()
 	}
 
-	if v, ok := j.(Priority); ok {
-		prio = v.Prio()
+	if delay < 0 {
+		delay = 0
 	}
 
-	_, err = q.tube.Put(body, prio, 0, q.TTR)
-	if err != nil {
+	return putUnique(q.Locker, q.Codec, j, func(body []byte) error {
+		_, err := q.tube.Put(body, prio, delay, q.TTR)
 		return err
-	}
+	})
+}
 
-	return nil
+// PutAt puts the job in the queue, the job
+// becomes ready at the specified time.
+func (q *BeanstalkQueue) PutAt(j Job, t time.Time) error {
+	return q.PutIn(j, time.Until(t))
 }
 
 // Get peeks a job from the queue.
 func (q *BeanstalkQueue) Get() (Message, error) {
 	id, payload, err := q.tset.Reserve(BeanstalkTimeout)
 	if err != nil {
-		if cerr, ok := err.(beanstalk.ConnError); ok && cerr.Err == beanstalk.ErrTimeout {
+		if isTimeout(err) {
 			return nil, &Error{Err: "timeout", IsTimeout: true}
 		}
 		return nil, err
 	}
 
-	msg, err := newBeanstalkMessage(id, payload)
+	msg, err := newBeanstalkMessage(q.Codec, id, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -143,49 +170,244 @@ func (q *BeanstalkQueue) Get() (Message, error) {
 // Delete deletes a job from the queue.
 func (q *BeanstalkQueue) Delete(m Message) error {
 	if env, ok := m.(*beanstalkMessage); ok {
-		return q.conn.Delete(env.ID)
+		if err := q.conn.Delete(env.id); err != nil {
+			return err
+		}
+		return unlockUnique(q.Locker, env.Envelope)
 	}
 
 	return NewErrorFmt("bad envelope: %v", m)
 }
 
-// Reject rejects the job marking it as failed.
-func (q *BeanstalkQueue) Reject(m Message) error {
-	if env, ok := m.(*beanstalkMessage); ok {
-		return q.conn.Bury(env.ID, q.Prio+1)
+// Release puts the job back in the queue incrementing its attempts
+// counter, the job becomes ready after the delay elapses.
+//
+// Beanstalk jobs are immutable, the job holding the updated counter
+// replaces the reserved one: like Reject, the new job is put before
+// the reserved one is deleted so the job is delivered at least once.
+func (q *BeanstalkQueue) Release(m Message, delay time.Duration) error {
+	env, ok := m.(*beanstalkMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
 	}
 
-	return NewErrorFmt("bad envelope: %v", m)
+	env.incAttempts()
+	body, err := encodeEnvelope(q.Codec, env.Envelope)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.tube.Put(body, q.prio(env.id), delay, q.TTR); err != nil {
+		return err
+	}
+
+	return q.conn.Delete(env.id)
 }
 
-// Size returns the queue size, only ready jobs are returned.
-func (q *BeanstalkQueue) Size() (uint64, uint64, error) {
-	parse := func(key string, dict map[string]string) (uint64, error) {
-		if v, ok := dict[key]; !ok {
-			return 0, NewErrorFmt("bad dict %v", v)
-		} else {
-			n, err := strconv.ParseUint(v, 10, 64)
-			if err != nil {
-				return 0, err
-			}
-			return n, nil
+// Defer puts the job back in the queue without counting
+// an attempt, the job becomes ready after the delay elapses.
+// The body is unchanged, the job is released natively.
+func (q *BeanstalkQueue) Defer(m Message, delay time.Duration) error {
+	env, ok := m.(*beanstalkMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	return q.conn.Release(env.id, q.prio(env.id), delay)
+}
+
+// prio returns the priority of the job, the queue
+// priority is returned if the job stats are unavailable.
+func (q *BeanstalkQueue) prio(id uint64) uint32 {
+	if stats, err := q.conn.StatsJob(id); err == nil {
+		if n, err := strconv.ParseUint(stats["pri"], 10, 32); err == nil {
+			return uint32(n)
 		}
 	}
+	return q.Prio
+}
 
+// Reject rejects the job moving it to the failed jobs tube.
+func (q *BeanstalkQueue) Reject(m Message, reason error) error {
+	env, ok := m.(*beanstalkMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	env.reject(reason)
+	body, err := encodeEnvelope(q.Codec, env.Envelope)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.dead.Put(body, q.Prio, 0, q.TTR); err != nil {
+		return err
+	}
+
+	if err := q.conn.Delete(env.id); err != nil {
+		return err
+	}
+	return unlockUnique(q.Locker, env.Envelope)
+}
+
+// Size returns the queue size, only ready jobs are returned.
+func (q *BeanstalkQueue) Size() (uint64, uint64, error) {
 	dict, err := q.tube.Stats()
 	if err != nil {
 		return 0, 0, err
 	}
 
-	ready, err := parse(beanstalkReadyKey, dict)
+	ready, err := parseStat(beanstalkReadyKey, dict)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	failed, err := parse(beanstalkFailedKey, dict)
+	// Jobs buried by previous versions count as failed too.
+	failed, err := parseStat(beanstalkFailedKey, dict)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The failed tube doesn't exist until a job is rejected.
+	dict, err = q.dead.Stats()
+	if isNotFound(err) {
+		return ready, failed, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	n, err := parseStat(beanstalkReadyKey, dict)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	return ready, failed, nil
+	return ready, failed + n, nil
+}
+
+// ListFailed returns up to limit failed jobs, the jobs are
+// reserved and then released back to the failed jobs tube.
+func (q *BeanstalkQueue) ListFailed(limit int) ([]*FailedJob, error) {
+	var jobs []*FailedJob
+	var ids []uint64
+
+	defer func() {
+		for _, id := range ids {
+			q.conn.Release(id, q.Prio, 0)
+		}
+	}()
+
+	for limit <= 0 || len(jobs) < limit {
+		id, body, err := q.dset.Reserve(0)
+		if isTimeout(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+
+		env, err := decodeEnvelope(q.Codec, body)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, env.failed(id))
+	}
+
+	return jobs, nil
+}
+
+// Requeue moves the failed job back to the queue.
+func (q *BeanstalkQueue) Requeue(id uint64) error {
+	stats, err := q.conn.StatsJob(id)
+	if err != nil {
+		return err
+	}
+
+	if stats["tube"] != q.FailedName {
+		return NewErrorFmt("job %v not found", id)
+	}
+
+	body, err := q.conn.Peek(id)
+	if err != nil {
+		return err
+	}
+
+	return q.requeue(id, body)
+}
+
+// RequeueAll moves all the failed jobs back to the queue, jobs
+// buried by previous versions are kicked back as well.
+func (q *BeanstalkQueue) RequeueAll() (int, error) {
+	n, err := q.tube.Kick(math.MaxInt32)
+	if err != nil {
+		return n, err
+	}
+
+	for {
+		id, body, err := q.dset.Reserve(0)
+		if isTimeout(err) {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		if err := q.requeue(id, body); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Purge deletes the failed job.
+func (q *BeanstalkQueue) Purge(id uint64) error {
+	stats, err := q.conn.StatsJob(id)
+	if err != nil {
+		return err
+	}
+
+	if stats["tube"] != q.FailedName {
+		return NewErrorFmt("job %v not found", id)
+	}
+
+	return q.conn.Delete(id)
+}
+
+// requeue puts the failed job body back in the
+// queue and deletes it from the failed tube.
+func (q *BeanstalkQueue) requeue(id uint64, body []byte) error {
+	env, err := decodeEnvelope(q.Codec, body)
+	if err != nil {
+		return err
+	}
+
+	env.reset()
+	if body, err = encodeEnvelope(q.Codec, env); err != nil {
+		return err
+	}
+
+	if _, err := q.tube.Put(body, q.Prio, 0, q.TTR); err != nil {
+		return err
+	}
+
+	return q.conn.Delete(id)
+}
+
+// parseStat parses a numeric value of a stats dict.
+func parseStat(key string, dict map[string]string) (uint64, error) {
+	v, ok := dict[key]
+	if !ok {
+		return 0, NewErrorFmt("bad dict %v", dict)
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+// isTimeout checks if err is a reserve timeout.
+func isTimeout(err error) bool {
+	cerr, ok := err.(beanstalk.ConnError)
+	return ok && cerr.Err == beanstalk.ErrTimeout
+}
+
+// isNotFound checks if err is a not found response.
+func isNotFound(err error) bool {
+	cerr, ok := err.(beanstalk.ConnError)
+	return ok && cerr.Err == beanstalk.ErrNotFound
 }
@@ -1,6 +1,7 @@
 package worker_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/vitalie/worker"
@@ -59,3 +60,46 @@ func TestBeanstalkQueue(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestBeanstalkDeadLetter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	q, err := worker.NewBeanstalkQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := q.(worker.DeadLetter)
+
+	if err := q.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Reject(msg, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := dl.ListFailed(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) == 0 {
+		t.Fatal("expecting failed jobs")
+	}
+
+	job := jobs[len(jobs)-1]
+	if job.Type != "addJob" || job.Failure.Err != "boom" {
+		t.Errorf("expecting addJob failed with boom, got %v failed with %v", job.Type, job.Failure)
+	}
+
+	if err := dl.Purge(job.ID); err != nil {
+		t.Error(err)
+	}
+}
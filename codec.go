@@ -0,0 +1,243 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/bitly/go-simplejson"
+)
+
+// Codec encodes the JSON payloads stored by the queues. The encoded
+// bodies start with a content type marker, consumers detect the codec
+// of each body so a queue can hold several formats while it's migrated.
+type Codec interface {
+	// ContentType returns the marker of the encoded bodies.
+	ContentType() string
+	// Encode returns the stored body of the JSON payload,
+	// including the marker (see MarkBody).
+	Encode(payload []byte) ([]byte, error)
+	// Decode returns the body wrapped by data, the data follows
+	// the marker and the returned body may be marked again.
+	Decode(data []byte) ([]byte, error)
+}
+
+// WrapperCodec is implemented by the codecs transforming
+// the bodies encoded by another codec (e.g. GzipCodec).
+type WrapperCodec interface {
+	Codec
+	Unwrap() Codec
+}
+
+const (
+	JSONContentType    = "application/json"
+	MsgpackContentType = "application/msgpack"
+	GzipContentType    = "gzip"
+)
+
+var (
+	JSONCodec    Codec = jsonCodec{}    // Default codec, the bodies aren't marked.
+	MsgpackCodec Codec = msgpackCodec{} // MessagePack codec.
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		JSONContentType:    JSONCodec,
+		MsgpackContentType: MsgpackCodec,
+		GzipContentType:    &GzipCodec{Codec: JSONCodec},
+	}
+)
+
+// RegisterCodec makes the codec available to the consumers
+// of every queue, the built-in codecs are registered already.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+// MarkBody returns data prefixed with the content type marker.
+func MarkBody(contentType string, data []byte) []byte {
+	body := make([]byte, 0, len(contentType)+2+len(data))
+	body = append(body, 0)
+	body = append(body, contentType...)
+	body = append(body, 0)
+	return append(body, data...)
+}
+
+// splitBody returns the content type and the data of a marked
+// body, unmarked bodies are JSON.
+func splitBody(body []byte) (string, []byte, error) {
+	if len(body) == 0 || body[0] != 0 {
+		return JSONContentType, body, nil
+	}
+
+	i := bytes.IndexByte(body[1:], 0)
+	if i < 0 {
+		return "", nil, NewError("bad body marker")
+	}
+	return string(body[1 : i+1]), body[i+2:], nil
+}
+
+// lookupCodec returns the codec of the content type, the
+// codecs of the queue have precedence over the registered ones.
+func lookupCodec(c Codec, contentType string) (Codec, error) {
+	for c != nil {
+		if c.ContentType() == contentType {
+			return c, nil
+		}
+
+		w, ok := c.(WrapperCodec)
+		if !ok {
+			break
+		}
+		c = w.Unwrap()
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	if c, ok := codecs[contentType]; ok {
+		return c, nil
+	}
+	return nil, NewErrorFmt("unknown codec: %v", contentType)
+}
+
+// encodeBody encodes the JSON payload using the codec, JSON if nil.
+func encodeBody(c Codec, payload []byte) ([]byte, error) {
+	if c == nil {
+		return payload, nil
+	}
+	return c.Encode(payload)
+}
+
+// decodeBody returns the JSON payload of the body, the markers
+// are decoded until an unmarked body is found.
+func decodeBody(c Codec, body []byte) ([]byte, error) {
+	for {
+		typ, data, err := splitBody(body)
+		if err != nil {
+			return nil, err
+		}
+
+		if typ == JSONContentType {
+			return data, nil
+		}
+
+		codec, err := lookupCodec(c, typ)
+		if err != nil {
+			return nil, err
+		}
+
+		if body, err = codec.Decode(data); err != nil {
+			return nil, NewErrorFmt("%v: %v", typ, err)
+		}
+	}
+}
+
+// undecodable is implemented by the messages which may fail decoding.
+type undecodable interface {
+	decodeErr() error
+}
+
+// decodeEnvelope returns the envelope of an encoded body. The bodies
+// which can't be decoded (e.g. encrypted with an unknown key) are kept
+// as is by the returned envelope, the pool rejects them without running.
+func decodeEnvelope(c Codec, body []byte) (*Envelope, error) {
+	payload, err := decodeBody(c, body)
+	if err == nil {
+		var env *Envelope
+		if env, err = NewEnvelope(payload); err == nil {
+			return env, nil
+		}
+	}
+
+	derr := NewErrorFmt("decode: %v", err)
+	v, err := NewFailure(derr).toData()
+	if err != nil {
+		return nil, err
+	}
+
+	json := simplejson.New()
+	json.Set("type", "")
+	json.Set("failure", v)
+
+	return &Envelope{data: &data{json}, raw: body, derr: derr}, nil
+}
+
+// encodeEnvelope returns the encoded body of the envelope,
+// the undecodable envelopes return their body unchanged.
+func encodeEnvelope(c Codec, e *Envelope) ([]byte, error) {
+	if e.raw != nil {
+		return e.raw, nil
+	}
+
+	payload, err := e.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return encodeBody(c, payload)
+}
+
+// jsonCodec stores the JSON payloads as is.
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                   { return JSONContentType }
+func (jsonCodec) Encode(payload []byte) ([]byte, error) { return payload, nil }
+func (jsonCodec) Decode(data []byte) ([]byte, error)    { return data, nil }
+
+// GzipCodec compresses the bodies encoded by Codec
+// which are larger than Threshold bytes.
+type GzipCodec struct {
+	Codec     Codec // Wrapped codec.
+	Threshold int   // Minimum compressed body size in bytes.
+	Level     int   // Compression level (see compress/gzip).
+}
+
+// NewGzipCodec returns a codec compressing the bodies of c
+// which are larger than threshold bytes.
+func NewGzipCodec(c Codec, threshold int) *GzipCodec {
+	return &GzipCodec{
+		Codec:     c,
+		Threshold: threshold,
+		Level:     gzip.DefaultCompression,
+	}
+}
+
+func (g *GzipCodec) ContentType() string { return GzipContentType }
+
+func (g *GzipCodec) Unwrap() Codec { return g.Codec }
+
+func (g *GzipCodec) Encode(payload []byte) ([]byte, error) {
+	body, err := encodeBody(g.Codec, payload)
+	if err != nil || len(body) <= g.Threshold {
+		return body, err
+	}
+
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, g.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return MarkBody(GzipContentType, b.Bytes()), nil
+}
+
+func (g *GzipCodec) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
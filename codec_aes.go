@@ -0,0 +1,180 @@
+package worker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+)
+
+// AESContentType marks the bodies encrypted by AESCodec.
+const AESContentType = "aes-gcm"
+
+// ErrUnknownKey is returned by a Keyring missing the requested key.
+var ErrUnknownKey = NewError("unknown key")
+
+// Keyring provides the encryption keys by ID, the primary key
+// encrypts the new bodies while the older keys still decrypt
+// the bodies they have encrypted.
+type Keyring interface {
+	Primary() (id string, key []byte, err error)
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeyring represents a keyring holding the keys in memory.
+type StaticKeyring struct {
+	mu      sync.RWMutex
+	primary string
+	keys    map[string][]byte
+}
+
+// NewStaticKeyring returns a keyring using the primary key ID,
+// the keys must be 16, 24 or 32 bytes long (AES-128, 192 or 256).
+func NewStaticKeyring(primary string, keys map[string][]byte) (*StaticKeyring, error) {
+	kr := &StaticKeyring{keys: map[string][]byte{}}
+	for id, key := range keys {
+		if err := kr.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := kr.Rotate(primary); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Add adds the key, an existing key with the same ID is replaced.
+func (kr *StaticKeyring) Add(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return NewErrorFmt("bad key ID: %q", id)
+	}
+
+	if _, err := aes.NewCipher(key); err != nil {
+		return NewErrorFmt("key %q: %v", id, err)
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys[id] = key
+	return nil
+}
+
+// Rotate makes the key having the ID primary.
+func (kr *StaticKeyring) Rotate(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, ok := kr.keys[id]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	kr.primary = id
+	return nil
+}
+
+// Primary returns the primary key.
+func (kr *StaticKeyring) Primary() (string, []byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.primary, kr.keys[kr.primary], nil
+}
+
+// Key returns the key having the ID.
+func (kr *StaticKeyring) Key(id string) ([]byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	key, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// AESCodec encrypts the bodies encoded by Codec using AES-GCM, the
+// encrypted data holds the key ID, the nonce and the sealed body.
+//
+// The bodies encrypted with a key missing from the keyring can't be
+// decoded: the pool rejects them keeping the encrypted body, they can
+// be requeued once the key is added (see DeadLetter).
+type AESCodec struct {
+	Codec   Codec   // Wrapped codec.
+	Keyring Keyring // Encryption keys.
+}
+
+// NewAESCodec returns a codec encrypting the bodies of c.
+func NewAESCodec(c Codec, kr Keyring) *AESCodec {
+	return &AESCodec{Codec: c, Keyring: kr}
+}
+
+func (a *AESCodec) ContentType() string { return AESContentType }
+
+func (a *AESCodec) Unwrap() Codec { return a.Codec }
+
+func (a *AESCodec) Encode(payload []byte) ([]byte, error) {
+	body, err := encodeBody(a.Codec, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	id, key, err := a.Keyring.Primary()
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if id == "" || len(id) > 255 {
+		return nil, NewErrorFmt("bad key ID: %q", id)
+	}
+
+	// The key ID is authenticated along with the body.
+	data := make([]byte, 0, 1+len(id)+gcm.NonceSize()+len(body)+gcm.Overhead())
+	data = append(data, byte(len(id)))
+	data = append(data, id...)
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	data = append(data, nonce...)
+
+	return MarkBody(AESContentType, gcm.Seal(data, nonce, body, data[:1+len(id)])), nil
+}
+
+func (a *AESCodec) Decode(data []byte) ([]byte, error) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, NewError("bad encrypted body")
+	}
+
+	n := 1 + int(data[0])
+	key, err := a.Keyring.Key(string(data[1:n]))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < n+gcm.NonceSize() {
+		return nil, NewError("bad encrypted body")
+	}
+
+	nonce, sealed := data[n:n+gcm.NonceSize()], data[n+gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, data[:n])
+}
+
+// newGCM returns the AES-GCM cipher of the key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,287 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// msgpackCodec stores the payloads in the MessagePack format,
+// only the types produced by JSON payloads are supported.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return MsgpackContentType }
+
+func (msgpackCodec) Encode(payload []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := packValue(&b, v); err != nil {
+		return nil, err
+	}
+	return MarkBody(MsgpackContentType, b.Bytes()), nil
+}
+
+func (msgpackCodec) Decode(data []byte) ([]byte, error) {
+	u := &unpacker{data: data}
+	v, err := u.value()
+	if err != nil {
+		return nil, err
+	}
+
+	if u.pos != len(u.data) {
+		return nil, NewError("msgpack: trailing data")
+	}
+	return json.Marshal(v)
+}
+
+// packValue appends the MessagePack encoding of a JSON value.
+func packValue(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+	case bool:
+		if v {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			packInt(b, n)
+			return nil
+		}
+
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			b.WriteByte(0xcf)
+			binary.Write(b, binary.BigEndian, n)
+			return nil
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		b.WriteByte(0xcb)
+		binary.Write(b, binary.BigEndian, math.Float64bits(f))
+	case string:
+		packLen(b, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		b.WriteString(v)
+	case []interface{}:
+		packLen(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := packValue(b, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		packLen(b, len(v), 0x80, 15, 0, 0xde, 0xdf)
+
+		// Sorted keys keep the encoding deterministic.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			packValue(b, k)
+			if err := packValue(b, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return NewErrorFmt("msgpack: unsupported type %T", v)
+	}
+	return nil
+}
+
+// packInt appends the smallest encoding of n.
+func packInt(b *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= 127:
+		b.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		b.WriteByte(byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		b.WriteByte(0xd0)
+		b.WriteByte(byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		b.WriteByte(0xd1)
+		binary.Write(b, binary.BigEndian, int16(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		b.WriteByte(0xd2)
+		binary.Write(b, binary.BigEndian, int32(n))
+	default:
+		b.WriteByte(0xd3)
+		binary.Write(b, binary.BigEndian, n)
+	}
+}
+
+// packLen appends a string, array or map header, fix is the fixed
+// format prefix holding lengths up to max, the 8 bits format is
+// used only if it's defined (non zero).
+func packLen(b *bytes.Buffer, n int, fix byte, max int, f8, f16, f32 byte) {
+	switch {
+	case n <= max:
+		b.WriteByte(fix | byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		b.WriteByte(f8)
+		b.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(f16)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(f32)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
+
+// unpacker decodes MessagePack values into JSON values.
+type unpacker struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next n bytes.
+func (u *unpacker) next(n int) ([]byte, error) {
+	if n < 0 || len(u.data)-u.pos < n {
+		return nil, NewError("msgpack: unexpected end of data")
+	}
+	p := u.data[u.pos : u.pos+n]
+	u.pos += n
+	return p, nil
+}
+
+// uint returns the next n bytes big endian unsigned integer.
+func (u *unpacker) uint(n int) (uint64, error) {
+	p, err := u.next(n)
+	if err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	for _, c := range p {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (u *unpacker) value() (interface{}, error) {
+	p, err := u.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := p[0]; {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0xa0 && c <= 0xbf:
+		return u.str(int(c & 0x1f))
+	case c >= 0x90 && c <= 0x9f:
+		return u.array(int(c & 0x0f))
+	case c >= 0x80 && c <= 0x8f:
+		return u.object(int(c & 0x0f))
+	}
+
+	switch c := p[0]; c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		v, err := u.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := u.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return u.uint(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		v, err := u.uint(n)
+		// Sign extend the n bytes value.
+		shift := 64 - 8*n
+		return int64(v<<shift) >> shift, err
+	case 0xd9, 0xda, 0xdb:
+		n, err := u.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return u.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := u.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return u.array(int(n))
+	case 0xde, 0xdf:
+		n, err := u.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return u.object(int(n))
+	}
+	return nil, NewErrorFmt("msgpack: unsupported format 0x%x", p[0])
+}
+
+func (u *unpacker) str(n int) (interface{}, error) {
+	p, err := u.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(p), nil
+}
+
+func (u *unpacker) array(n int) (interface{}, error) {
+	if n > len(u.data)-u.pos {
+		return nil, NewError("msgpack: unexpected end of data")
+	}
+
+	items := make([]interface{}, n)
+	for i := range items {
+		v, err := u.value()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+func (u *unpacker) object(n int) (interface{}, error) {
+	if n > len(u.data)-u.pos {
+		return nil, NewError("msgpack: unexpected end of data")
+	}
+
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := u.value()
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := k.(string)
+		if !ok {
+			return nil, NewErrorFmt("msgpack: bad key type %T", k)
+		}
+
+		if m[key], err = u.value(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,236 @@
+package worker_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+func TestMsgpackCodec(t *testing.T) {
+	payload := `{"args":{"A":[1,-1,200,-200,70000,-70000,5000000000,1.5,true,false,null],"S":"` +
+		strings.Repeat("x", 300) + `","M":{}},"type":"msgJob"}`
+
+	body, err := worker.MsgpackCodec.Encode([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marker := worker.MarkBody(worker.MsgpackContentType, nil)
+	if !bytes.HasPrefix(body, marker) || len(body) >= len(payload) {
+		t.Fatalf("expecting a compact marked body, got %q", body)
+	}
+
+	got, err := worker.MsgpackCodec.Decode(body[len(marker):])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want, have interface{}
+	json.Unmarshal([]byte(payload), &want)
+	json.Unmarshal(got, &have)
+
+	wantJSON, _ := json.Marshal(want)
+	haveJSON, _ := json.Marshal(have)
+	if !bytes.Equal(wantJSON, haveJSON) {
+		t.Errorf("expecting %s, got %s", wantJSON, haveJSON)
+	}
+
+	// The integers above MaxInt64 are kept exact.
+	payload = `{"args":{"N":18446744073709551615}}`
+	if body, err = worker.MsgpackCodec.Encode([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err = worker.MsgpackCodec.Decode(body[len(marker):]); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != payload {
+		t.Errorf("expecting %s, got %s", payload, got)
+	}
+}
+
+func TestGzipCodec(t *testing.T) {
+	c := worker.NewGzipCodec(worker.JSONCodec, 64)
+
+	small, err := c.Encode([]byte(`{"type":"addJob"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Small bodies aren't compressed.
+	if string(small) != `{"type":"addJob"}` {
+		t.Errorf("expecting plain body, got %q", small)
+	}
+
+	q := worker.NewMemoryQueue()
+	q.(*worker.MemoryQueue).Codec = worker.NewGzipCodec(worker.MsgpackCodec, 0)
+
+	if err := q.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Type() != "addJob" || msg.Args().Get("Y").MustInt(-1) != 2 {
+		t.Errorf("expecting addJob(1, 2), got %v", msg)
+	}
+}
+
+func TestFileQueueCodecMigration(t *testing.T) {
+	dir := t.TempDir()
+	q := openFileQueue(t, dir, func(q *worker.FileQueue) {
+		q.Codec = worker.MsgpackCodec
+	})
+
+	if err := q.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+	q.(*worker.FileQueue).Close()
+
+	// The consumers detect the codec of the stored jobs.
+	q = openFileQueue(t, dir)
+	defer q.(*worker.FileQueue).Close()
+
+	if err := q.Put(&addJob{X: 3, Y: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := 0
+	for i := 0; i < 2; i++ {
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += msg.Args().Get("X").MustInt(-1) + msg.Args().Get("Y").MustInt(-1)
+	}
+
+	if sum != 10 {
+		t.Errorf("expecting sum to be %v, got %v", 10, sum)
+	}
+}
+
+func TestAESCodec(t *testing.T) {
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+
+	old, err := worker.NewStaticKeyring("k1", map[string][]byte{"k1": k1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr, err := worker.NewStaticKeyring("k2", map[string][]byte{"k1": k1, "k2": k2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := worker.NewStaticKeyring("k3", nil); !errors.Is(err, worker.ErrUnknownKey) {
+		t.Errorf("expecting %v, got %v", worker.ErrUnknownKey, err)
+	}
+
+	payload := []byte(`{"args":{"To":"a@example.com"},"type":"mailJob"}`)
+	c := worker.NewAESCodec(worker.JSONCodec, old)
+
+	body, err := c.Encode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(body, []byte("a@example.com")) {
+		t.Fatalf("expecting encrypted body, got %q", body)
+	}
+
+	// The rotated keyring still decrypts the bodies of the older keys.
+	marker := worker.MarkBody(worker.AESContentType, nil)
+	got, err := worker.NewAESCodec(worker.JSONCodec, kr).Decode(body[len(marker):])
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("expecting %s, got %s (%v)", payload, got, err)
+	}
+
+	// Tampered bodies are refused.
+	body[len(body)-1] ^= 1
+	if _, err := c.Decode(body[len(marker):]); err == nil {
+		t.Error("expecting authentication error")
+	}
+}
+
+func TestAESCodecUnknownKey(t *testing.T) {
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	// The producer encrypts with a key unknown to the consumer.
+	dir := t.TempDir()
+	producer, _ := worker.NewStaticKeyring("k2", map[string][]byte{"k2": k2})
+	q := openFileQueue(t, dir, func(q *worker.FileQueue) {
+		q.Codec = worker.NewAESCodec(worker.JSONCodec, producer)
+	})
+
+	if err := q.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+	q.(*worker.FileQueue).Close()
+
+	consumer, _ := worker.NewStaticKeyring("k1", map[string][]byte{"k1": k1})
+	q = openFileQueue(t, dir, func(q *worker.FileQueue) {
+		q.Codec = worker.NewAESCodec(worker.JSONCodec, consumer)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+	pool.Add(&addJob{})
+
+	done := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(done)
+	}()
+
+	// The undecodable job is rejected without stopping the pool.
+	var failed uint64
+	for deadline := time.Now().Add(time.Second); failed == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		_, failed, _ = q.Size()
+	}
+	cancel()
+	<-done
+
+	jobs, err := q.(worker.DeadLetter).ListFailed(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 1 || !strings.Contains(jobs[0].Failure.Err, "unknown key") {
+		t.Fatalf("expecting unknown key failure, got %+v", jobs)
+	}
+	q.(*worker.FileQueue).Close()
+
+	// The job is recovered once the key is added.
+	consumer.Add("k2", k2)
+	q = openFileQueue(t, dir, func(q *worker.FileQueue) {
+		q.Codec = worker.NewAESCodec(worker.JSONCodec, consumer)
+	})
+	defer q.(*worker.FileQueue).Close()
+
+	if err := q.(worker.DeadLetter).Requeue(jobs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Type() != "addJob" || msg.Args().Get("Y").MustInt(-1) != 2 {
+		t.Errorf("expecting addJob(1, 2), got %v", msg)
+	}
+}
@@ -0,0 +1,244 @@
+package worker
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes the activation times of a recurring job.
+type Schedule interface {
+	// Next returns the next activation time after t,
+	// the zero time is returned if there is none.
+	Next(t time.Time) time.Time
+}
+
+// cronField describes the bounds of a cron field.
+type cronField struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	cronMinutes = cronField{0, 59, nil}
+	cronHours   = cronField{0, 23, nil}
+	cronDays    = cronField{1, 31, nil}
+	cronMonths  = cronField{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronWeekdays = cronField{0, 6, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// cronSchedule represents a standard 5 fields cron schedule,
+// each field is stored as a bit set of the allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// everySchedule represents a fixed interval schedule,
+// activations are aligned to the interval.
+type everySchedule struct {
+	every time.Duration
+}
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(s.every).Add(s.every)
+}
+
+// ParseSchedule parses a cron expression, standard 5 fields
+// expressions (minute, hour, day of month, month, day of week)
+// and descriptors (@hourly, @daily, @every 5m, etc.) are supported.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, NewErrorFmt("bad schedule %q: %v", spec, err)
+		}
+		if d < time.Second {
+			return nil, NewErrorFmt("bad schedule %q: interval too short", spec)
+		}
+		return everySchedule{d}, nil
+	}
+
+	if v, ok := cronDescriptors[spec]; ok {
+		spec = v
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, NewErrorFmt("bad schedule %q: expecting 5 fields", spec)
+	}
+
+	s := &cronSchedule{
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}
+
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&s.minute, cronMinutes},
+		{&s.hour, cronHours},
+		{&s.dom, cronDays},
+		{&s.month, cronMonths},
+		{&s.dow, cronWeekdays},
+	} {
+		if *f.bits, err = parseCronField(fields[i], f.field); err != nil {
+			return nil, NewErrorFmt("bad schedule %q: %v", spec, err)
+		}
+	}
+
+	// Sunday can be specified as 7 too.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of
+// ranges with optional steps (e.g. "1-5,*/15").
+func parseCronField(expr string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		step := uint(1)
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.ParseUint(part[i+1:], 10, 8)
+			if err != nil || n == 0 {
+				return 0, NewErrorFmt("bad step %q", part)
+			}
+			step, part = uint(n), part[:i]
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if lo, err = f.value(part[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(part[i+1:]); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := f.value(part)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+
+		if lo > hi {
+			return 0, NewErrorFmt("bad range %q", part)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// value parses a single field value, names are case insensitive.
+func (f cronField) value(s string) (uint, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, NewErrorFmt("bad value %q", s)
+	}
+
+	// Allow 7 for Sunday in day of week field.
+	max := f.max
+	if f.names != nil && f.max == 6 {
+		max = 7
+	}
+
+	if uint(n) < f.min || uint(n) > max {
+		return 0, NewErrorFmt("value %q out of range", s)
+	}
+
+	return uint(n), nil
+}
+
+// Next returns the next activation time after t, the search
+// gives up after 5 years returning the zero time. The wall clock
+// hour repeated when DST ends is activated once.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc))
+	limit := t.Year() + 5
+
+	for t.Year() <= limit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc))
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// forward returns next if it's after t, otherwise the next minute
+// of t in absolute time: when DST ends the wall clock repeats an
+// hour and time.Date resolves it to the earlier occurrence.
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Truncate(time.Minute).Add(time.Minute)
+}
+
+// dayMatches checks the day of month and day of week fields,
+// if both are restricted it's enough for one of them to match.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
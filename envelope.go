@@ -1,7 +1,14 @@
 package worker
 
+import (
+	"encoding/json"
+)
+
 type Envelope struct {
 	*data
+
+	raw  []byte // Stored body of an undecodable envelope.
+	derr error  // Decoding failure.
 }
 
 func NewEnvelope(body []byte) (*Envelope, error) {
@@ -25,6 +32,84 @@ func (e *Envelope) Args() *Args {
 	}
 }
 
+// Attempts returns the number of the failed attempts.
+func (e *Envelope) Attempts() int {
+	return e.Get("attempts").MustInt(0)
+}
+
+// Version returns the payload version (see Versioner).
+func (e *Envelope) Version() int {
+	return e.Get("version").MustInt(0)
+}
+
+// Meta returns the message metadata, an empty metadata is
+// returned for the messages enqueued without metadata.
+func (e *Envelope) Meta() *Meta {
+	meta := &Meta{}
+	if v, ok := e.CheckGet("meta"); ok {
+		if body, err := v.MarshalJSON(); err == nil {
+			json.Unmarshal(body, meta)
+		}
+	}
+
+	meta.Attempts = e.Attempts()
+	return meta
+}
+
+// decodeErr returns the decoding failure of the envelope.
+func (e *Envelope) decodeErr() error {
+	return e.derr
+}
+
+// incAttempts increments the failed attempts counter.
+func (e *Envelope) incAttempts() {
+	e.Set("attempts", e.Attempts()+1)
+}
+
+// Failure returns the failure details of a rejected
+// message, nil is returned if there are none.
+func (e *Envelope) Failure() *Failure {
+	v, ok := e.CheckGet("failure")
+	if !ok {
+		return nil
+	}
+
+	body, err := v.MarshalJSON()
+	if err != nil {
+		return nil
+	}
+
+	f := &Failure{}
+	if err := json.Unmarshal(body, f); err != nil {
+		return nil
+	}
+	return f
+}
+
+// reject records the failure details.
+func (e *Envelope) reject(err error) {
+	if v, err := NewFailure(err).toData(); err == nil {
+		e.Set("failure", v)
+	}
+}
+
+// reset clears the failure details before requeueing.
+func (e *Envelope) reset() {
+	e.Del("failure")
+	e.Del("attempts")
+}
+
+// failed returns the failed job details.
+func (e *Envelope) failed(id uint64) *FailedJob {
+	return &FailedJob{
+		ID:       id,
+		Type:     e.Type(),
+		Args:     e.Args(),
+		Attempts: e.Attempts(),
+		Failure:  e.Failure(),
+	}
+}
+
 func (e *Envelope) String() string {
 	if e == nil {
 		return "<nil>"
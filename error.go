@@ -1,7 +1,9 @@
 package worker
 
 import (
+	"errors"
 	"fmt"
+	"time"
 )
 
 type Error struct {
@@ -27,3 +29,49 @@ func NewErrorFmt(format string, args ...interface{}) error {
 	return NewError(fmt.Sprintf(format, args...))
 
 }
+
+// DeferError asks the pool to put the job back in the queue
+// without counting an attempt, e.g. when the job is throttled.
+type DeferError struct {
+	Delay time.Duration
+}
+
+func (e *DeferError) Error() string {
+	return fmt.Sprintf("worker: deferred for %v", e.Delay)
+}
+
+// NewDeferError returns an error deferring the job for delay.
+func NewDeferError(delay time.Duration) error {
+	return &DeferError{Delay: delay}
+}
+
+// PermanentError marks a failure which must not be retried,
+// the job is rejected regardless of the retry policy.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// NewPermanentError returns err marked as permanent.
+func NewPermanentError(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// wrapError prefixes the error message with op,
+// permanent errors are kept permanent.
+func wrapError(op string, err error) error {
+	e := NewErrorFmt("%v: %v", op, err)
+
+	var perr *PermanentError
+	if errors.As(err, &perr) {
+		return NewPermanentError(e)
+	}
+	return e
+}
@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	FailureStackSize = 4 * 1024 // Max stack trace size stored with a failure.
+)
+
+var hostname, _ = os.Hostname()
+
+// Failure represents the details of a rejected job,
+// it's persisted along with the job by the queues.
+type Failure struct {
+	Err   string    `json:"error"`           // Error message.
+	Panic bool      `json:"panic,omitempty"` // Job panicked.
+	Stack string    `json:"stack,omitempty"` // Truncated stack trace.
+	Time  time.Time `json:"time"`            // Failure time.
+	Host  string    `json:"host,omitempty"`  // Worker host.
+}
+
+// NewFailure returns the failure details of err, if err
+// is a Failure already the missing details are filled in.
+func NewFailure(err error) *Failure {
+	f, ok := err.(*Failure)
+	if !ok {
+		f = &Failure{}
+		if err != nil {
+			f.Err = err.Error()
+		}
+	}
+
+	if f.Time.IsZero() {
+		f.Time = time.Now().UTC()
+	}
+
+	if f.Host == "" {
+		f.Host = hostname
+	}
+
+	if len(f.Stack) > FailureStackSize {
+		f.Stack = f.Stack[:FailureStackSize]
+	}
+
+	return f
+}
+
+// newPanicFailure returns the failure details of a recovered panic.
+func newPanicFailure(v interface{}, stack []byte) *Failure {
+	return NewFailure(&Failure{
+		Err:   fmt.Sprintf("panic: %v", v),
+		Panic: true,
+		Stack: string(stack),
+	})
+}
+
+func (f *Failure) Error() string {
+	if f == nil {
+		return "<nil>"
+	}
+
+	return f.Err
+}
+
+// toData returns the failure as generic JSON data.
+func (f *Failure) toData() (interface{}, error) {
+	body, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+
+	json, err := toJson(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Interface(), nil
+}
@@ -0,0 +1,329 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when the log is flushed to disk.
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota // Fsync after each write.
+	SyncInterval                   // Fsync periodically (see FileQueue.SyncEvery).
+	SyncNever                      // Leave flushing to the operating system.
+)
+
+const (
+	fileSegmentExt = ".log"
+	fileHeaderSize = 8 // Record length and checksum.
+)
+
+// fileRecord represents a log entry.
+type fileRecord struct {
+	Op   string `json:"op"`
+	ID   uint64 `json:"id"`
+	Prio uint32 `json:"prio,omitempty"`
+	At   int64  `json:"at,omitempty"`
+	Body []byte `json:"body,omitempty"`
+}
+
+// fileLog represents an append-only log split in numbered
+// segments, each record is framed by its length and checksum.
+type fileLog struct {
+	mu     sync.Mutex
+	dir    string
+	policy SyncPolicy
+	limit  int64 // Segment size limit.
+
+	seq   uint64 // Current segment number.
+	file  *os.File
+	w     *bufio.Writer
+	size  int64
+	dirty bool
+}
+
+// openFileLog opens the log stored in dir, replaying
+// the existing records through fn.
+func openFileLog(dir string, policy SyncPolicy, limit int64, fn func(*fileRecord)) (*fileLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l := &fileLog{dir: dir, policy: policy, limit: limit}
+
+	segments, err := l.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, seq := range segments {
+		last := i == len(segments)-1
+		if err := l.replay(seq, last, fn); err != nil {
+			return nil, err
+		}
+		l.seq = seq
+	}
+
+	if err := l.open(l.seq); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// segments returns the segment numbers in ascending order.
+func (l *fileLog) segments() ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(l.dir, "*"+fileSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []uint64
+	for _, name := range names {
+		base := strings.TrimSuffix(filepath.Base(name), fileSegmentExt)
+		if seq, err := strconv.ParseUint(base, 10, 64); err == nil {
+			segments = append(segments, seq)
+		}
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// path returns the file path of the segment.
+func (l *fileLog) path(seq uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%016d%s", seq, fileSegmentExt))
+}
+
+// replay reads the segment records, a torn record at the
+// end of the last segment is truncated.
+func (l *fileLog) replay(seq uint64, last bool, fn func(*fileRecord)) error {
+	f, err := os.Open(l.path(seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := readFileRecord(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			if !last {
+				return NewErrorFmt("corrupted segment %v at %v: %v", l.path(seq), offset, err)
+			}
+			return os.Truncate(l.path(seq), offset)
+		}
+
+		fn(rec)
+		offset += n
+	}
+}
+
+// readFileRecord reads a single record and returns its size.
+func readFileRecord(r io.Reader) (*fileRecord, int64, error) {
+	var header [fileHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+		return nil, 0, io.EOF
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:4])
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, err
+	}
+
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, NewError("bad checksum")
+	}
+
+	rec := &fileRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, 0, err
+	}
+
+	return rec, int64(fileHeaderSize + size), nil
+}
+
+// open opens the segment for appending.
+func (l *fileLog) open(seq uint64) error {
+	f, err := os.OpenFile(l.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.seq, l.file, l.size = seq, f, info.Size()
+	l.w = bufio.NewWriter(f)
+	return nil
+}
+
+// append writes the records, the segment is rotated
+// once it exceeds the size limit.
+func (l *fileLog) append(recs ...*fileRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, rec := range recs {
+		if err := l.write(rec); err != nil {
+			return err
+		}
+	}
+
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	l.dirty = true
+
+	if l.policy == SyncAlways {
+		if err := l.sync(); err != nil {
+			return err
+		}
+	}
+
+	if l.limit > 0 && l.size >= l.limit {
+		return l.rotate()
+	}
+	return nil
+}
+
+func (l *fileLog) write(rec *fileRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	var header [fileHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+
+	if _, err := l.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := l.w.Write(data); err != nil {
+		return err
+	}
+
+	l.size += int64(len(header) + len(data))
+	return nil
+}
+
+// rotate closes the current segment and opens the next one.
+func (l *fileLog) rotate() error {
+	if err := l.sync(); err != nil {
+		return err
+	}
+
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	return l.open(l.seq + 1)
+}
+
+// compact writes the snapshot records in a new segment
+// and removes the previous segments.
+func (l *fileLog) compact(snapshot []*fileRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old := l.seq
+	if err := l.rotate(); err != nil {
+		return err
+	}
+
+	for _, rec := range snapshot {
+		if err := l.write(rec); err != nil {
+			return err
+		}
+	}
+
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+
+	// The snapshot must be durable before dropping the history.
+	if err := l.sync(); err != nil {
+		return err
+	}
+
+	segments, err := l.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range segments {
+		if seq <= old {
+			if err := os.Remove(l.path(seq)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Sync flushes the pending writes to disk.
+func (l *fileLog) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.sync()
+}
+
+func (l *fileLog) sync() error {
+	if !l.dirty {
+		return nil
+	}
+
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// Close syncs and closes the current segment.
+func (l *fileLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.sync(); err != nil {
+		return err
+	}
+	return l.file.Close()
+}
+
+// syncer flushes the log periodically until done is closed.
+func (l *fileLog) syncer(every time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			l.Sync()
+		}
+	}
+}
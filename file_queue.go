@@ -0,0 +1,575 @@
+package worker
+
+import (
+	"container/heap"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	FilePrio = 100 // File queue default job priority.
+
+	fileReady    = "ready"
+	fileReserved = "reserved"
+	fileFailed   = "failed"
+
+	fileOpPut     = "put"
+	fileOpReserve = "reserve"
+	fileOpDelete  = "delete"
+	fileOpRelease = "release"
+	fileOpReject  = "reject"
+	fileOpCounter = "counter"
+)
+
+var (
+	FileSegmentSize  int64         = 64 << 20        // File queue segment size limit.
+	FileSyncEvery    time.Duration = 1 * time.Second // File queue sync interval (see SyncInterval).
+	FileCompactEvery               = 10000           // File queue records written between compactions.
+)
+
+// fileMessage represents data returned by Get.
+type fileMessage struct {
+	id        uint64 // Message ID.
+	*Envelope        // Holds parsed json.
+}
+
+// fileJob represents the state of a stored job.
+type fileJob struct {
+	id     uint64
+	prio   uint32
+	at     time.Time
+	body   []byte
+	status string
+	gen    uint64 // Invalidates stale heap items.
+}
+
+// fileItem represents a heap entry, the entry is stale if
+// the job was updated since it was pushed.
+type fileItem struct {
+	job *fileJob
+	gen uint64
+}
+
+func (i fileItem) valid() bool { return i.job.status == fileReady && i.gen == i.job.gen }
+
+// fileHeap orders ready jobs by priority or delayed jobs by time.
+type fileHeap struct {
+	items  []fileItem
+	byTime bool
+}
+
+func (h *fileHeap) Len() int { return len(h.items) }
+func (h *fileHeap) Less(i, j int) bool {
+	a, b := h.items[i].job, h.items[j].job
+	if h.byTime && !a.at.Equal(b.at) {
+		return a.at.Before(b.at)
+	}
+	if a.prio != b.prio {
+		return a.prio < b.prio
+	}
+	return a.id < b.id
+}
+func (h *fileHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *fileHeap) Push(x interface{}) { h.items = append(h.items, x.(fileItem)) }
+func (h *fileHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+// FileQueue represents a durable queue stored on local disk.
+//
+// Every change is appended to a write-ahead log split in segments,
+// on open the log is replayed and the jobs which were reserved but
+// not acknowledged are returned to the ready state. The log is
+// compacted periodically by writing the live jobs in a new segment.
+type FileQueue struct {
+	Dir          string        // Log directory.
+	Sync         SyncPolicy    // Log flush policy.
+	SyncEvery    time.Duration // Log flush interval (see SyncInterval).
+	SegmentSize  int64         // Log segment size limit.
+	CompactEvery int           // Records written between compactions.
+	Prio         uint32        // Default job priority.
+	Locker       Locker        // Unique jobs lock store.
+	Codec        Codec         // Payloads codec.
+
+	mu      sync.Mutex
+	log     *fileLog
+	jobs    map[uint64]*fileJob
+	ready   *fileHeap
+	delayed *fileHeap
+	counter uint64
+	written int
+	done    chan struct{}
+	closed  bool
+}
+
+// NewFileQueue opens the queue stored in dir using custom options.
+func NewFileQueue(dir string, opts ...func(*FileQueue)) (Queue, error) {
+	q := &FileQueue{
+		Dir:          dir,
+		Sync:         SyncInterval,
+		SyncEvery:    FileSyncEvery,
+		SegmentSize:  FileSegmentSize,
+		CompactEvery: FileCompactEvery,
+		Prio:         FilePrio,
+		Locker:       NewMemoryLocker(),
+		Codec:        JSONCodec,
+		jobs:         map[uint64]*fileJob{},
+		ready:        &fileHeap{},
+		delayed:      &fileHeap{byTime: true},
+		done:         make(chan struct{}),
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	log, err := openFileLog(q.Dir, q.Sync, q.SegmentSize, q.apply)
+	if err != nil {
+		return nil, err
+	}
+	q.log = log
+
+	// Build the heaps, the in-flight jobs of
+	// the previous run are returned as ready.
+	for _, job := range q.jobs {
+		if job.status == fileReady || job.status == fileReserved {
+			q.push(job, job.at)
+		}
+	}
+
+	if q.Sync == SyncInterval {
+		go q.log.syncer(q.SyncEvery, q.done)
+	}
+
+	return q, nil
+}
+
+// Close flushes and closes the log, closing
+// a closed queue has no effect.
+func (q *FileQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+
+	close(q.done)
+	return q.log.Close()
+}
+
+// apply updates the state using a replayed record,
+// the heaps are built once the replay is over.
+func (q *FileQueue) apply(rec *fileRecord) {
+	if rec.ID > q.counter {
+		q.counter = rec.ID
+	}
+
+	job := q.jobs[rec.ID]
+	switch rec.Op {
+	case fileOpPut:
+		job = &fileJob{id: rec.ID, prio: rec.Prio}
+		q.jobs[rec.ID] = job
+		fallthrough
+	case fileOpRelease:
+		if job == nil {
+			return
+		}
+		job.at = time.UnixMilli(rec.At)
+		job.body = rec.Body
+		job.status = fileReady
+	case fileOpReserve:
+		if job != nil {
+			job.status = fileReserved
+		}
+	case fileOpReject:
+		if job != nil {
+			job.body = rec.Body
+			job.status = fileFailed
+		}
+	case fileOpDelete:
+		delete(q.jobs, rec.ID)
+	}
+}
+
+// Put puts the job in the queue.
+func (q *FileQueue) Put(j Job) error {
+	return q.PutIn(j, 0)
+}
+
+// PutIn puts the job in the queue, the job becomes
+// ready after the delay elapses.
+func (q *FileQueue) PutIn(j Job, delay time.Duration) error {
+	prio := q.Prio
+
+	if v, ok := unwrapJob(j).(Priority); ok {
+		prio = v.Prio()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return putUnique(q.Locker, q.Codec, j, func(body []byte) error {
+		q.counter++
+		job := &fileJob{id: q.counter, prio: prio, body: body}
+		at := time.Now().Add(delay)
+
+		err := q.write(&fileRecord{Op: fileOpPut, ID: job.id, Prio: prio, At: at.UnixMilli(), Body: body})
+		if err != nil {
+			return err
+		}
+
+		q.jobs[job.id] = job
+		q.push(job, at)
+
+		return nil
+	})
+}
+
+// PutAt puts the job in the queue, the job
+// becomes ready at the specified time.
+func (q *FileQueue) PutAt(j Job, t time.Time) error {
+	return q.PutIn(j, time.Until(t))
+}
+
+// Get reserves the most urgent ready job.
+func (q *FileQueue) Get() (Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.promote(time.Now())
+
+	for q.ready.Len() > 0 {
+		item := heap.Pop(q.ready).(fileItem)
+		if !item.valid() {
+			continue
+		}
+
+		job := item.job
+		if err := q.write(&fileRecord{Op: fileOpReserve, ID: job.id}); err != nil {
+			heap.Push(q.ready, item)
+			return nil, err
+		}
+		job.status = fileReserved
+
+		return newFileMessage(q.Codec, job.id, job.body)
+	}
+
+	return nil, &Error{Err: "timeout", IsTimeout: true}
+}
+
+// Delete deletes a job from the queue.
+func (q *FileQueue) Delete(m Message) error {
+	env, ok := m.(*fileMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.write(&fileRecord{Op: fileOpDelete, ID: env.id}); err != nil {
+		return err
+	}
+	delete(q.jobs, env.id)
+
+	return unlockUnique(q.Locker, env.Envelope)
+}
+
+// Release puts the job back in the queue incrementing its attempts
+// counter, the job becomes ready after the delay elapses.
+func (q *FileQueue) Release(m Message, delay time.Duration) error {
+	env, ok := m.(*fileMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	env.incAttempts()
+	return q.Defer(env, delay)
+}
+
+// Defer puts the job back in the queue without counting
+// an attempt, the job becomes ready after the delay elapses.
+func (q *FileQueue) Defer(m Message, delay time.Duration) error {
+	env, ok := m.(*fileMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	body, err := encodeEnvelope(q.Codec, env.Envelope)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.release(env.id, body, time.Now().Add(delay))
+}
+
+// Reject rejects the job marking it as failed.
+func (q *FileQueue) Reject(m Message, reason error) error {
+	env, ok := m.(*fileMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	env.reject(reason)
+	body, err := encodeEnvelope(q.Codec, env.Envelope)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[env.id]
+	if !ok {
+		return NewErrorFmt("job %v not found", env.id)
+	}
+
+	if err := q.write(&fileRecord{Op: fileOpReject, ID: job.id, Body: body}); err != nil {
+		return err
+	}
+	job.body = body
+	job.status = fileFailed
+	job.gen++
+
+	return unlockUnique(q.Locker, env.Envelope)
+}
+
+// Size returns the number of ready and failed jobs.
+func (q *FileQueue) Size() (uint64, uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ready, failed uint64
+	now := time.Now()
+	for _, job := range q.jobs {
+		switch {
+		case job.status == fileReady && !job.at.After(now):
+			ready++
+		case job.status == fileFailed:
+			failed++
+		}
+	}
+
+	return ready, failed, nil
+}
+
+// ListFailed returns up to limit failed jobs, all the
+// failed jobs are returned if limit is not positive.
+func (q *FileQueue) ListFailed(limit int) ([]*FailedJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ids []uint64
+	for _, job := range q.jobs {
+		if job.status == fileFailed {
+			ids = append(ids, job.id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var jobs []*FailedJob
+	for _, id := range ids {
+		if limit > 0 && len(jobs) >= limit {
+			break
+		}
+
+		env, err := decodeEnvelope(q.Codec, q.jobs[id].body)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, env.failed(id))
+	}
+
+	return jobs, nil
+}
+
+// Requeue moves the failed job back to the queue.
+func (q *FileQueue) Requeue(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.requeue(id)
+}
+
+// RequeueAll moves all the failed jobs back to the queue.
+func (q *FileQueue) RequeueAll() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := 0
+	for id, job := range q.jobs {
+		if job.status != fileFailed {
+			continue
+		}
+
+		if err := q.requeue(id); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// Purge deletes the failed job.
+func (q *FileQueue) Purge(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok || job.status != fileFailed {
+		return NewErrorFmt("job %v not found", id)
+	}
+
+	if err := q.write(&fileRecord{Op: fileOpDelete, ID: id}); err != nil {
+		return err
+	}
+	delete(q.jobs, id)
+
+	return nil
+}
+
+// Compact rewrites the log keeping only the live jobs.
+func (q *FileQueue) Compact() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.compact()
+}
+
+func (q *FileQueue) compact() error {
+	ids := make([]uint64, 0, len(q.jobs))
+	for id := range q.jobs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var snapshot []*fileRecord
+	for _, id := range ids {
+		job := q.jobs[id]
+		snapshot = append(snapshot, &fileRecord{Op: fileOpPut, ID: id, Prio: job.prio, At: job.at.UnixMilli(), Body: job.body})
+
+		switch job.status {
+		case fileReserved:
+			snapshot = append(snapshot, &fileRecord{Op: fileOpReserve, ID: id})
+		case fileFailed:
+			snapshot = append(snapshot, &fileRecord{Op: fileOpReject, ID: id, Body: job.body})
+		}
+	}
+
+	// Keep the counter to avoid reusing the IDs.
+	snapshot = append(snapshot, &fileRecord{Op: fileOpCounter, ID: q.counter})
+
+	if err := q.log.compact(snapshot); err != nil {
+		return err
+	}
+	q.written = 0
+
+	return nil
+}
+
+// requeue resets the failed job and marks it as ready.
+func (q *FileQueue) requeue(id uint64) error {
+	job, ok := q.jobs[id]
+	if !ok || job.status != fileFailed {
+		return NewErrorFmt("job %v not found", id)
+	}
+
+	env, err := decodeEnvelope(q.Codec, job.body)
+	if err != nil {
+		return err
+	}
+
+	env.reset()
+	body, err := encodeEnvelope(q.Codec, env)
+	if err != nil {
+		return err
+	}
+
+	return q.release(id, body, time.Now())
+}
+
+// release stores the new body and marks the job as ready at time at.
+func (q *FileQueue) release(id uint64, body []byte, at time.Time) error {
+	job, ok := q.jobs[id]
+	if !ok {
+		return NewErrorFmt("job %v not found", id)
+	}
+
+	if err := q.write(&fileRecord{Op: fileOpRelease, ID: id, At: at.UnixMilli(), Body: body}); err != nil {
+		return err
+	}
+	job.body = body
+	q.push(job, at)
+
+	return nil
+}
+
+// write appends the record to the log, the log is compacted
+// first when due so that a failure leaves the state unchanged.
+func (q *FileQueue) write(rec *fileRecord) error {
+	if q.CompactEvery > 0 && q.written >= q.CompactEvery {
+		if err := q.compact(); err != nil {
+			return err
+		}
+	}
+
+	if err := q.log.append(rec); err != nil {
+		return err
+	}
+	q.written++
+
+	return nil
+}
+
+// push marks the job as ready at time at.
+func (q *FileQueue) push(job *fileJob, at time.Time) {
+	job.at = at
+	job.status = fileReady
+	job.gen++
+
+	item := fileItem{job: job, gen: job.gen}
+	if at.After(time.Now()) {
+		heap.Push(q.delayed, item)
+	} else {
+		heap.Push(q.ready, item)
+	}
+}
+
+// promote moves the due delayed jobs to the ready heap.
+func (q *FileQueue) promote(now time.Time) {
+	for q.delayed.Len() > 0 && !q.delayed.items[0].job.at.After(now) {
+		if item := heap.Pop(q.delayed).(fileItem); item.valid() {
+			heap.Push(q.ready, item)
+		}
+	}
+}
+
+// newFileMessage returns an instance of fileMessage.
+func newFileMessage(c Codec, id uint64, payload []byte) (*fileMessage, error) {
+	base, err := decodeEnvelope(c, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &fileMessage{
+		id:       id,
+		Envelope: base,
+	}
+
+	return env, nil
+}
+
+// ID returns the backend ID of the message.
+func (m *fileMessage) ID() string { return strconv.FormatUint(m.id, 10) }
@@ -0,0 +1,198 @@
+package worker_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vitalie/worker"
+)
+
+// openFileQueue opens the queue stored in dir.
+func openFileQueue(t *testing.T, dir string, opts ...func(*worker.FileQueue)) worker.Queue {
+	q, err := worker.NewFileQueue(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestFileQueue(t *testing.T) {
+	q := openFileQueue(t, t.TempDir())
+	defer q.(*worker.FileQueue).Close()
+
+	for i, p := range []uint32{200, 10} {
+		if err := q.Put(&prioJob{X: i, P: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range []int{1, 0} {
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if x := msg.Args().Get("X").MustInt(-1); x != want {
+			t.Errorf("expecting X to be %v, got %v", want, x)
+		}
+
+		if err := q.Delete(msg); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := q.Get(); err == nil {
+		t.Error("expecting empty queue")
+	}
+}
+
+func TestFileQueueRecovery(t *testing.T) {
+	dir := t.TempDir()
+	q := openFileQueue(t, dir, func(q *worker.FileQueue) {
+		q.Sync = worker.SyncAlways
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := q.Put(&addJob{X: i, Y: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Leave the first job in-flight, complete the second
+	// one and reject the third one.
+	var msgs []worker.Message
+	for i := 0; i < 3; i++ {
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if err := q.Delete(msgs[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Reject(msgs[2], errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	q.(*worker.FileQueue).Close()
+
+	// Simulate a torn write at the end of the log.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+
+	q = openFileQueue(t, dir)
+	defer q.(*worker.FileQueue).Close()
+
+	ready, failed, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ready != 1 || failed != 1 {
+		t.Errorf("expecting (1, 1), got (%v, %v)", ready, failed)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x := msg.Args().Get("X").MustInt(-1); x != 0 {
+		t.Errorf("expecting X to be %v, got %v", 0, x)
+	}
+
+	jobs, err := q.(worker.DeadLetter).ListFailed(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 1 || jobs[0].Failure.Err != "boom" {
+		t.Errorf("expecting a job failed with boom, got %v", jobs)
+	}
+}
+
+func TestFileQueueCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := func(q *worker.FileQueue) {
+		q.CompactEvery = 10
+		q.SegmentSize = 512
+	}
+
+	q := openFileQueue(t, dir, opts)
+	for i := 0; i < 50; i++ {
+		if err := q.Put(&addJob{X: i, Y: i}); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Keep every tenth job.
+		if i%10 == 0 {
+			err = q.Reject(msg, errors.New("boom"))
+		} else {
+			err = q.Delete(msg)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.(*worker.FileQueue).Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(segments) > 3 {
+		t.Errorf("expecting compacted log, got %v segments", len(segments))
+	}
+
+	q = openFileQueue(t, dir, opts)
+	defer q.(*worker.FileQueue).Close()
+
+	ready, failed, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ready != 0 || failed != 5 {
+		t.Errorf("expecting (0, 5), got (%v, %v)", ready, failed)
+	}
+
+	if err := q.Put(&addJob{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// IDs must not be reused after compaction.
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Reject(msg, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, failed, _ := q.Size(); failed != 6 {
+		t.Errorf("expecting failed to be %v, got %v", 6, failed)
+	}
+}
+
+func TestFileQueueClose(t *testing.T) {
+	q := openFileQueue(t, t.TempDir())
+
+	for i := 0; i < 2; i++ {
+		if err := q.(*worker.FileQueue).Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
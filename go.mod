@@ -12,4 +12,5 @@ require (
 	github.com/caio/go-tdigest v3.1.0+incompatible // indirect
 	github.com/jonboulle/clockwork v0.1.1-0.20190114141812-62fb9bc030d1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/sys v0.34.0 // indirect
 )
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+)
+
+// headersJob represents a job carrying metadata headers,
+// the headers are stored in the message envelope.
+type headersJob struct {
+	Job
+	headers map[string]string
+}
+
+// WithHeaders returns the job carrying the headers, the headers
+// are available to the middleware through the job context
+// (see HeadersFromContext).
+func WithHeaders(j Job, headers map[string]string) Job {
+	merged := map[string]string{}
+	for k, v := range jobHeaders(j) {
+		merged[k] = v
+	}
+	for k, v := range headers {
+		merged[k] = v
+	}
+
+	if h, ok := j.(*headersJob); ok {
+		j = h.Job
+	}
+	return &headersJob{Job: j, headers: merged}
+}
+
+// unwrapJob returns the job without its headers and context
+// adapter, the arguments are returned for typed jobs (see NewJob).
+func unwrapJob(j Job) interface{} {
+	if h, ok := j.(*headersJob); ok {
+		j = h.Job
+	}
+
+	var v interface{} = j
+	if c, ok := j.(*contextJob); ok {
+		v = c.job
+	}
+
+	if t, ok := v.(argsJob); ok {
+		return t.jobArgs()
+	}
+	return v
+}
+
+// unwrapFactory returns the factory without its context adapter.
+func unwrapFactory(f Factory) interface{} {
+	if c, ok := f.(*contextJob); ok {
+		return c.job
+	}
+	return f
+}
+
+// jobHeaders returns the headers carried by the job.
+func jobHeaders(j Job) map[string]string {
+	if h, ok := j.(*headersJob); ok {
+		return h.headers
+	}
+	return nil
+}
+
+type headersKey struct{}
+
+// ContextWithHeaders returns a copy of ctx carrying the message headers.
+func ContextWithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	return context.WithValue(ctx, headersKey{}, headers)
+}
+
+// HeadersFromContext returns the headers of the running message.
+func HeadersFromContext(ctx context.Context) map[string]string {
+	h, _ := ctx.Value(headersKey{}).(map[string]string)
+	return h
+}
@@ -1,8 +1,10 @@
 package worker
 
 import (
+	"encoding/json"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/bitly/go-simplejson"
 )
@@ -15,7 +17,20 @@ func toJson(data []byte) (*simplejson.Json, error) {
 	}
 }
 
+// StructType returns the wire type of the job, the
+// declared type (see TypeNamer) or the struct name.
 func StructType(v interface{}) (string, error) {
+	if j, ok := v.(Job); ok {
+		v = unwrapJob(j)
+	}
+
+	if n, ok := v.(TypeNamer); ok {
+		if typ := n.JobType(); typ != "" {
+			return typ, nil
+		}
+		return "", NewError("bad job type")
+	}
+
 	typ := reflect.TypeOf(v)
 
 	// If job is a pointer get the type
@@ -36,3 +51,39 @@ func StructType(v interface{}) (string, error) {
 
 	return "", NewError("bad struct name")
 }
+
+// marshalJob returns the job payload encoded as JSON, unique
+// and token are the uniqueness lock key and token of the job.
+func marshalJob(j Job, unique, token string) ([]byte, error) {
+	typ, err := StructType(j)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Payload{
+		Type:    typ,
+		Args:    unwrapJob(j),
+		Unique:  unique,
+		Token:   token,
+		Version: jobVersion(j),
+	}
+
+	job.Meta = newMeta(time.Now())
+	if headers := jobHeaders(j); len(headers) > 0 {
+		job.Meta.Headers = headers
+	}
+
+	return json.Marshal(job)
+}
+
+// jobVersion returns the payload version of the job.
+func jobVersion(v interface{}) int {
+	if j, ok := v.(Job); ok {
+		v = unwrapJob(j)
+	}
+
+	if n, ok := v.(Versioner); ok {
+		return n.JobVersion()
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+)
+
+// Locker represents a lock store shared by the processes
+// which need to coordinate (e.g. several schedulers).
+type Locker interface {
+	// Lock acquires the key for the ttl duration, it returns
+	// the lock token or an empty token if the key is held already.
+	Lock(key string, ttl time.Duration) (string, error)
+
+	// Unlock releases the key if it's still held with the token,
+	// a lock which expired and was acquired again is kept.
+	Unlock(key, token string) error
+}
+
+// newLockToken returns a random lock token.
+func newLockToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// memoryLock represents a lock held by MemoryLocker.
+type memoryLock struct {
+	token string
+	exp   time.Time
+}
+
+// MemoryLocker represents a process local lock store,
+// this locker is used mainly for unit tests.
+type MemoryLocker struct {
+	mu   sync.Mutex
+	keys map[string]memoryLock
+}
+
+func NewMemoryLocker() Locker {
+	return &MemoryLocker{
+		keys: map[string]memoryLock{},
+	}
+}
+
+func (l *MemoryLocker) Lock(key string, ttl time.Duration) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if lock, ok := l.keys[key]; ok && lock.exp.After(now) {
+		return "", nil
+	}
+
+	// Drop expired keys to keep the map small.
+	for k, lock := range l.keys {
+		if !lock.exp.After(now) {
+			delete(l.keys, k)
+		}
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return "", err
+	}
+
+	l.keys[key] = memoryLock{token: token, exp: now.Add(ttl)}
+	return token, nil
+}
+
+func (l *MemoryLocker) Unlock(key, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lock, ok := l.keys[key]; ok && lock.token == token {
+		delete(l.keys, key)
+	}
+	return nil
+}
+
+// redisUnlockScript deletes the key only if it holds the token.
+const redisUnlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+// RedisLocker represents a lock store shared through Redis.
+type RedisLocker struct {
+	Host     string // Redis host.
+	Port     string // Redis port.
+	Password string // Redis password.
+	Prefix   string // Keys prefix.
+
+	conn *redisConn
+}
+
+// NewRedisLocker returns a locker instance using custom options.
+func NewRedisLocker(opts ...func(*RedisLocker)) (Locker, error) {
+	l := &RedisLocker{
+		Host:   RedisHost,
+		Port:   RedisPort,
+		Prefix: "worker:lock",
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	conn, err := newRedisConn(net.JoinHostPort(l.Host, l.Port), l.Password, RedisIOTimeout)
+	if err != nil {
+		return nil, err
+	}
+	l.conn = conn
+
+	return l, nil
+}
+
+func (l *RedisLocker) Lock(key string, ttl time.Duration) (string, error) {
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return "", err
+	}
+
+	v, err := l.conn.do("SET", l.Prefix+":"+key, token, "NX", "PX", ms)
+	if err != nil || v == nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (l *RedisLocker) Unlock(key, token string) error {
+	_, err := l.conn.do("EVAL", redisUnlockScript, 1, l.Prefix+":"+key, token)
+	return err
+}
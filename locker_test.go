@@ -0,0 +1,56 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+func TestLocker(t *testing.T) {
+	host, port := newRedisServer(t).addr()
+	redis, err := worker.NewRedisLocker(func(l *worker.RedisLocker) {
+		l.Host, l.Port = host, port
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, l := range map[string]worker.Locker{
+		"memory": worker.NewMemoryLocker(),
+		"redis":  redis,
+	} {
+		stale, err := l.Lock("key", 50*time.Millisecond)
+		if err != nil || stale == "" {
+			t.Fatalf("%v: expecting lock, got %q (%v)", name, stale, err)
+		}
+
+		if token, _ := l.Lock("key", time.Minute); token != "" {
+			t.Errorf("%v: expecting held key", name)
+		}
+
+		// The expired lock is acquired by another owner,
+		// the stale token doesn't release it.
+		time.Sleep(100 * time.Millisecond)
+		token, err := l.Lock("key", time.Minute)
+		if err != nil || token == "" {
+			t.Fatalf("%v: expecting lock, got %q (%v)", name, token, err)
+		}
+
+		if err := l.Unlock("key", stale); err != nil {
+			t.Fatal(err)
+		}
+
+		if v, _ := l.Lock("key", time.Minute); v != "" {
+			t.Errorf("%v: expecting key held by the new owner", name)
+		}
+
+		if err := l.Unlock("key", token); err != nil {
+			t.Fatal(err)
+		}
+
+		if v, _ := l.Lock("key", time.Minute); v == "" {
+			t.Errorf("%v: expecting released key", name)
+		}
+	}
+}
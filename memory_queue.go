@@ -1,78 +1,105 @@
 package worker
 
 import (
-	"encoding/json"
+	"container/heap"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type memoryMessage struct {
-	ID uint64
+	id uint64
+	At time.Time // Time when a delayed message becomes ready.
 	*Envelope
 }
 
-func newMemoryMessage(id uint64, payload []byte) (*memoryMessage, error) {
-	base, err := NewEnvelope(payload)
+// delayedMessages represents a time ordered heap of messages.
+type delayedMessages []*memoryMessage
+
+func (h delayedMessages) Len() int            { return len(h) }
+func (h delayedMessages) Less(i, j int) bool  { return h[i].At.Before(h[j].At) }
+func (h delayedMessages) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *delayedMessages) Push(x interface{}) { *h = append(*h, x.(*memoryMessage)) }
+func (h *delayedMessages) Pop() interface{} {
+	old := *h
+	n := len(old)
+	m := old[n-1]
+	*h = old[:n-1]
+	return m
+}
+
+func newMemoryMessage(c Codec, id uint64, payload []byte) (*memoryMessage, error) {
+	base, err := decodeEnvelope(c, payload)
 	if err != nil {
 		return nil, err
 	}
 
 	env := &memoryMessage{
-		ID:       id,
+		id:       id,
 		Envelope: base,
 	}
 
 	return env, nil
 }
 
+// ID returns the backend ID of the message.
+func (m *memoryMessage) ID() string { return strconv.FormatUint(m.id, 10) }
+
 // MemoryQueue represents an ordered queue,
 // this queue is used mainly for unit tests.
 type MemoryQueue struct {
 	sync.Mutex
+	Locker  Locker // Unique jobs lock store.
+	Codec   Codec  // Payloads codec.
 	counter uint64
 	ready   []*memoryMessage
+	delayed delayedMessages
 	failed  []*memoryMessage
 }
 
 func NewMemoryQueue() Queue {
 	return &MemoryQueue{
+		Locker: NewMemoryLocker(),
+		Codec:  JSONCodec,
 		ready:  []*memoryMessage{},
 		failed: []*memoryMessage{},
 	}
 }
 
 func (q *MemoryQueue) Put(j Job) error {
+	return q.PutIn(j, 0)
+}
+
+// PutIn puts the job in the queue, the job becomes
+// ready after the delay elapses.
+func (q *MemoryQueue) PutIn(j Job, delay time.Duration) error {
 	q.Lock()
 	defer q.Unlock()
 
-	typ, err := StructType(j)
-	if err != nil {
-		return err
-	}
-
-	job := &Payload{
-		Type: typ,
-		Args: j,
-	}
-
-	payload, err := json.Marshal(job)
-	if err != nil {
-		return err
-	}
+	return putUnique(q.Locker, q.Codec, j, func(payload []byte) error {
+		q.counter++
+		msg, err := newMemoryMessage(q.Codec, q.counter, payload)
+		if err != nil {
+			return err
+		}
+		q.push(msg, delay)
 
-	q.counter++
-	msg, err := newMemoryMessage(q.counter, payload)
-	if err != nil {
-		return err
-	}
-	q.ready = append(q.ready, msg)
+		return nil
+	})
+}
 
-	return nil
+// PutAt puts the job in the queue, the job
+// becomes ready at the specified time.
+func (q *MemoryQueue) PutAt(j Job, t time.Time) error {
+	return q.PutIn(j, time.Until(t))
 }
 
 func (q *MemoryQueue) Get() (Message, error) {
 	q.Lock()
 	defer q.Unlock()
 
+	q.promote(time.Now())
+
 	if len(q.ready) == 0 {
 		return nil, &Error{Err: "timeout", IsTimeout: true}
 	}
@@ -91,20 +118,113 @@ func (q *MemoryQueue) Delete(msg Message) error {
 		return NewErrorFmt("bad envelope: %v", msg)
 	}
 
-	_, q.ready = q.remove(env.ID, q.ready)
+	_, q.ready = q.remove(env.id, q.ready)
+
+	return unlockUnique(q.Locker, env.Envelope)
+}
+
+func (q *MemoryQueue) Release(msg Message, delay time.Duration) error {
+	env, ok := msg.(*memoryMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", msg)
+	}
+
+	env.incAttempts()
+	return q.Defer(env, delay)
+}
+
+// Defer puts the job back in the queue without counting
+// an attempt, the job becomes ready after the delay elapses.
+func (q *MemoryQueue) Defer(msg Message, delay time.Duration) error {
+	q.Lock()
+	defer q.Unlock()
+
+	env, ok := msg.(*memoryMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", msg)
+	}
+
+	_, q.ready = q.remove(env.id, q.ready)
+	q.push(env, delay)
 
 	return nil
 }
 
-func (q *MemoryQueue) Reject(msg Message) error {
+func (q *MemoryQueue) Reject(msg Message, reason error) error {
+	q.Lock()
+	defer q.Unlock()
+
 	env, ok := msg.(*memoryMessage)
 	if !ok {
 		return NewErrorFmt("bad envelope: %v", msg)
 	}
 
-	m, l := q.remove(env.ID, q.ready)
-	q.ready = l
-	q.failed = append(q.failed, m)
+	_, q.ready = q.remove(env.id, q.ready)
+	env.reject(reason)
+	q.failed = append(q.failed, env)
+
+	return unlockUnique(q.Locker, env.Envelope)
+}
+
+// ListFailed returns up to limit failed jobs, all
+// the failed jobs are returned if limit is not positive.
+func (q *MemoryQueue) ListFailed(limit int) ([]*FailedJob, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	var jobs []*FailedJob
+	for _, m := range q.failed {
+		if limit > 0 && len(jobs) >= limit {
+			break
+		}
+		jobs = append(jobs, m.failed(m.id))
+	}
+
+	return jobs, nil
+}
+
+// Requeue moves the failed job back to ready list.
+func (q *MemoryQueue) Requeue(id uint64) error {
+	q.Lock()
+	defer q.Unlock()
+
+	var m *memoryMessage
+	m, q.failed = q.remove(id, q.failed)
+	if m == nil {
+		return NewErrorFmt("job %v not found", id)
+	}
+
+	m.reset()
+	q.ready = append(q.ready, m)
+
+	return nil
+}
+
+// RequeueAll moves all the failed jobs back to ready list.
+func (q *MemoryQueue) RequeueAll() (int, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	n := len(q.failed)
+	for _, m := range q.failed {
+		m.reset()
+		q.ready = append(q.ready, m)
+	}
+	q.failed = []*memoryMessage{}
+
+	return n, nil
+}
+
+// Purge deletes the failed job.
+func (q *MemoryQueue) Purge(id uint64) error {
+	q.Lock()
+	defer q.Unlock()
+
+	var m *memoryMessage
+	m, q.failed = q.remove(id, q.failed)
+	if m == nil {
+		return NewErrorFmt("job %v not found", id)
+	}
 
 	return nil
 }
@@ -119,12 +239,31 @@ func (q *MemoryQueue) Size() (uint64, uint64, error) {
 	return uint64(ready), uint64(failed), nil
 }
 
+// push appends the message to ready list or
+// to delayed set if the delay is positive.
+func (q *MemoryQueue) push(msg *memoryMessage, delay time.Duration) {
+	if delay <= 0 {
+		q.ready = append(q.ready, msg)
+		return
+	}
+
+	msg.At = time.Now().Add(delay)
+	heap.Push(&q.delayed, msg)
+}
+
+// promote moves the due delayed messages to ready list.
+func (q *MemoryQueue) promote(now time.Time) {
+	for len(q.delayed) > 0 && !q.delayed[0].At.After(now) {
+		q.ready = append(q.ready, heap.Pop(&q.delayed).(*memoryMessage))
+	}
+}
+
 func (q *MemoryQueue) remove(id uint64, list []*memoryMessage) (*memoryMessage, []*memoryMessage) {
 	var m *memoryMessage
 	var l []*memoryMessage
 
 	for _, i := range list {
-		if i.ID != id {
+		if i.id != id {
 			l = append(l, i)
 		} else {
 			m = i
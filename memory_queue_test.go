@@ -1,7 +1,9 @@
 package worker_test
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/vitalie/worker"
 )
@@ -55,3 +57,214 @@ func TestMemoryQueue(t *testing.T) {
 		t.Errorf("expecting size to be %v, got %v", 0, size)
 	}
 }
+
+func TestMemoryQueueRelease(t *testing.T) {
+	q := worker.NewMemoryQueue()
+
+	if err := q.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Release(msg, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.Get(); err == nil {
+		t.Error("expecting delayed message to be hidden")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	msg, err = q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Attempts() != 1 {
+		t.Errorf("expecting attempts to be %v, got %v", 1, msg.Attempts())
+	}
+
+	// Deferred messages keep their attempts counter.
+	if err := q.(worker.Deferrer).Defer(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg, err = q.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Attempts() != 1 {
+		t.Errorf("expecting attempts to be %v, got %v", 1, msg.Attempts())
+	}
+}
+
+func TestMemoryQueueDelayed(t *testing.T) {
+	q := worker.NewMemoryQueue()
+
+	if err := q.PutAt(&addJob{X: 2, Y: 2}, time.Now().Add(40*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.PutIn(&addJob{X: 1, Y: 1}, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.Get(); err == nil {
+		t.Error("expecting delayed messages to be hidden")
+	}
+
+	for _, want := range []int{1, 2} {
+		time.Sleep(20 * time.Millisecond)
+
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if x := msg.Args().Get("X").MustInt(-1); x != want {
+			t.Errorf("expecting X to be %v, got %v", want, x)
+		}
+	}
+}
+
+func TestMemoryQueueDeadLetter(t *testing.T) {
+	q := worker.NewMemoryQueue()
+	dl := q.(worker.DeadLetter)
+
+	for i := 0; i < 3; i++ {
+		if err := q.Put(&addJob{X: i, Y: i}); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := q.Reject(msg, errors.New("boom")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs, err := dl.ListFailed(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 2 {
+		t.Fatalf("expecting %v jobs, got %v", 2, len(jobs))
+	}
+
+	if jobs[0].Type != "addJob" || jobs[0].Failure.Err != "boom" {
+		t.Errorf("expecting addJob failed with boom, got %v failed with %v", jobs[0].Type, jobs[0].Failure)
+	}
+
+	if err := dl.Requeue(jobs[0].ID); err != nil {
+		t.Error(err)
+	}
+
+	if err := dl.Purge(jobs[1].ID); err != nil {
+		t.Error(err)
+	}
+
+	if err := dl.Purge(jobs[1].ID); err == nil {
+		t.Error("expecting purged job to be missing")
+	}
+
+	if n, err := dl.RequeueAll(); err != nil || n != 1 {
+		t.Errorf("expecting %v requeued jobs, got %v (%v)", 1, n, err)
+	}
+
+	ready, failed, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ready != 2 || failed != 0 {
+		t.Errorf("expecting (2, 0), got (%v, %v)", ready, failed)
+	}
+}
+
+// mailJob represents a unique test job.
+type mailJob struct {
+	To string
+}
+
+func (j *mailJob) Make(args *worker.Args) (worker.Job, error) { return &mailJob{}, nil }
+
+func (j *mailJob) Run() error { return nil }
+
+func (j *mailJob) UniqueKey() string { return j.To }
+
+func (j *mailJob) UniqueFor() time.Duration { return time.Minute }
+
+func TestMemoryQueueUnique(t *testing.T) {
+	q := worker.NewMemoryQueue()
+
+	if err := q.Put(&mailJob{To: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Put(&mailJob{To: "a@example.com"}); err != worker.ErrDuplicate {
+		t.Errorf("expecting %v, got %v", worker.ErrDuplicate, err)
+	}
+
+	if err := q.Put(&mailJob{To: "b@example.com"}); err != nil {
+		t.Error(err)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The job is still unique while it's running.
+	to := msg.Args().Get("To").MustString("")
+	if err := q.Put(&mailJob{To: to}); err != worker.ErrDuplicate {
+		t.Errorf("expecting %v, got %v", worker.ErrDuplicate, err)
+	}
+
+	if err := q.Delete(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Put(&mailJob{To: to}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryQueueMeta(t *testing.T) {
+	q := worker.NewMemoryQueue()
+
+	if err := q.Put(worker.WithHeaders(&addJob{X: 1, Y: 2}, map[string]string{"tenant": "acme"})); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := msg.Meta()
+	if len(meta.ID) != 26 || meta.EnqueuedAt.IsZero() || meta.Host == "" || meta.Headers["tenant"] != "acme" {
+		t.Fatalf("expecting job metadata, got %+v", meta)
+	}
+
+	if err := q.Release(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg, err = q.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retries keep the job ID.
+	if got := msg.Meta(); got.ID != meta.ID || got.Attempts != 1 {
+		t.Errorf("expecting %v attempt 1, got %v attempt %v", meta.ID, got.ID, got.Attempts)
+	}
+}
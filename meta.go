@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+)
+
+// Meta represents the message metadata, it's stored in
+// the payload meta section when the job is enqueued.
+type Meta struct {
+	ID         string            `json:"id,omitempty"`         // Job ID (ULID), kept across retries.
+	EnqueuedAt time.Time         `json:"enqueued_at,omitzero"` // Time when the job was enqueued.
+	Host       string            `json:"host,omitempty"`       // Enqueuing host.
+	Headers    map[string]string `json:"headers,omitempty"`    // Custom headers (see WithHeaders).
+	Attempts   int               `json:"-"`                    // Failed attempts, stored by the queues.
+}
+
+// newMeta returns the metadata of a job enqueued at t.
+func newMeta(t time.Time) *Meta {
+	return &Meta{
+		ID:         newULID(t),
+		EnqueuedAt: t.UTC(),
+		Host:       hostname,
+	}
+}
+
+// crockford is the ULID encoding alphabet.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID, a lexicographically sortable ID made
+// of a 48 bits millisecond timestamp and 80 random bits.
+func newULID(t time.Time) string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(t.UnixMilli())<<16)
+	rand.Read(id[6:])
+
+	// Encode the 128 bits as 26 base32 characters,
+	// the first character holds the 3 top bits.
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
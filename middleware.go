@@ -1,15 +1,20 @@
 package worker
 
-type JobRunner func(sw StatusWriter, fact string, args *Args)
+import (
+	"context"
+	"log/slog"
+)
+
+type JobRunner func(ctx context.Context, sw StatusWriter, fact string, args *Args)
 
 type Handler interface {
-	Exec(sw StatusWriter, fact string, args *Args, next JobRunner)
+	Exec(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner)
 }
 
-type HandlerFunc func(sw StatusWriter, fact string, args *Args, next JobRunner)
+type HandlerFunc func(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner)
 
-func (h HandlerFunc) Exec(sw StatusWriter, fact string, args *Args, next JobRunner) {
-	h(sw, fact, args, next)
+func (h HandlerFunc) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner) {
+	h(ctx, sw, fact, args, next)
 }
 
 type middleware struct {
@@ -17,12 +22,13 @@ type middleware struct {
 	next    *middleware
 }
 
-func (m middleware) Exec(sw StatusWriter, fact string, args *Args) {
-	m.handler.Exec(sw, fact, args, m.next.Exec)
+func (m middleware) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args) {
+	m.handler.Exec(ctx, sw, fact, args, m.next.Exec)
 }
 
 // CommonStack is used to configure default middleware
-// that's common for most applications (middlewares: Recovery, Logger).
+// that's common for most applications (middlewares: Recovery, Logger),
+// it's replaced by StructuredStack if the pool logger is set.
 func CommonStack() []Handler {
 	return []Handler{NewRecovery(), NewLogger()}
 }
@@ -32,3 +38,46 @@ func CommonStack() []Handler {
 func AirbreakStack(id int64, key, env string) []Handler {
 	return []Handler{NewAirbrake(id, key, env), NewLogger()}
 }
+
+type messageKey struct{}
+
+type loggerKey struct{}
+
+// contextWithLogger returns a copy of ctx carrying the pool logger.
+func contextWithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFromContext returns l, the pool logger carried
+// by ctx is returned if l is nil.
+func loggerFromContext(ctx context.Context, l *slog.Logger) *slog.Logger {
+	if l != nil {
+		return l
+	}
+
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// contextWithMessage returns a copy of ctx carrying the running message.
+func contextWithMessage(ctx context.Context, msg Message) context.Context {
+	return context.WithValue(ctx, messageKey{}, msg)
+}
+
+// MetaFromContext returns the metadata of the running message,
+// nil is returned outside of a job context.
+func MetaFromContext(ctx context.Context) *Meta {
+	if msg, ok := MessageFromContext(ctx); ok {
+		return msg.Meta()
+	}
+	return nil
+}
+
+// MessageFromContext returns the running message, it's
+// available to the middleware through the job context.
+func MessageFromContext(ctx context.Context) (Message, bool) {
+	msg, ok := ctx.Value(messageKey{}).(Message)
+	return msg, ok
+}
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"log"
 	"os"
 	"runtime"
@@ -39,7 +40,7 @@ func NewAirbrakeWithHost(id int64, key, env, host string) *Airbrake {
 	}
 }
 
-func (r *Airbrake) Exec(sw StatusWriter, fact string, args *Args, next JobRunner) {
+func (r *Airbrake) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner) {
 	// Deferred function calls are executed in Last In First Out order
 	// after the surrounding function returns.
 	defer r.Airbrake.Flush()
@@ -51,7 +52,7 @@ func (r *Airbrake) Exec(sw StatusWriter, fact string, args *Args, next JobRunner
 
 			f := "%s: PANIC: %s\n%s"
 			r.Logger.Printf(f, jinfo, err, stack)
-			sw.Set(err)
+			sw.Set(newPanicFailure(err, stack))
 
 			notice := r.Airbrake.Notice(err, nil, 1)
 			notice.Params["job_type"] = fact
@@ -60,5 +61,5 @@ func (r *Airbrake) Exec(sw StatusWriter, fact string, args *Args, next JobRunner
 		}
 	}()
 
-	next(sw, fact, args)
+	next(ctx, sw, fact, args)
 }
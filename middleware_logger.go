@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -15,13 +16,13 @@ func NewLogger() *Logger {
 	return &Logger{log.New(os.Stdout, "[worker] ", 0)}
 }
 
-func (l *Logger) Exec(sw StatusWriter, fact string, args *Args, next JobRunner) {
+func (l *Logger) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner) {
 	jinfo := fact + " " + args.String()
 	start := time.Now()
 
 	l.Println(jinfo, "started ...")
 
-	next(sw, fact, args)
+	next(ctx, sw, fact, args)
 
 	status := "OK"
 	if !sw.OK() {
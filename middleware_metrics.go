@@ -0,0 +1,269 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetricsBuckets are the default job duration histogram buckets in seconds.
+var DefaultMetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// outcome represents the way a job run ended.
+type outcome int
+
+const (
+	outcomeSucceeded outcome = iota
+	outcomeFailed
+	outcomePanicked
+	outcomeDeferred
+)
+
+// typeMetrics represents the metrics of a job type.
+type typeMetrics struct {
+	started  uint64
+	outcomes [4]uint64 // Counts by outcome.
+	inflight int64
+	buckets  []uint64 // Counts by duration bucket.
+	count    uint64
+	sum      float64
+}
+
+// Metrics records the jobs metrics and exposes them with the
+// watched pools gauges in the Prometheus text format.
+type Metrics struct {
+	Namespace string    // Metric names prefix.
+	Buckets   []float64 // Duration histogram buckets in seconds, sorted by NewMetrics.
+
+	mu    sync.Mutex
+	types map[string]*typeMetrics
+	pools map[string]*Pool
+}
+
+// NewMetrics returns a metrics middleware using custom options.
+func NewMetrics(opts ...func(*Metrics)) *Metrics {
+	m := &Metrics{
+		Namespace: "worker",
+		Buckets:   DefaultMetricsBuckets,
+		types:     map[string]*typeMetrics{},
+		pools:     map[string]*Pool{},
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	// The buckets upper bounds must be increasing.
+	buckets := make([]float64, 0, len(m.Buckets))
+	for _, le := range m.Buckets {
+		if !math.IsNaN(le) && !math.IsInf(le, 1) {
+			buckets = append(buckets, le)
+		}
+	}
+	sort.Float64s(buckets)
+	m.Buckets = slices.Compact(buckets)
+
+	return m
+}
+
+// Watch exposes the workers and queue gauges of the pool.
+func (m *Metrics) Watch(name string, p *Pool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pools[name] = p
+}
+
+func (m *Metrics) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner) {
+	start := time.Now()
+	m.start(fact)
+
+	// A panicking job unwinds through the middleware
+	// when it's placed after Recovery.
+	done := false
+	defer func() {
+		if !done {
+			m.done(fact, start, outcomePanicked)
+		}
+	}()
+
+	next(ctx, sw, fact, args)
+	done = true
+
+	var derr *DeferError
+	var f *Failure
+
+	switch err := sw.Get(); {
+	case err == nil:
+		m.done(fact, start, outcomeSucceeded)
+	case errors.As(err, &derr):
+		m.done(fact, start, outcomeDeferred)
+	case errors.As(err, &f) && f.Panic:
+		m.done(fact, start, outcomePanicked)
+	default:
+		m.done(fact, start, outcomeFailed)
+	}
+}
+
+// metrics returns the metrics of the job type, it must be called holding mu.
+func (m *Metrics) metrics(fact string) *typeMetrics {
+	t, ok := m.types[fact]
+	if !ok {
+		t = &typeMetrics{buckets: make([]uint64, len(m.Buckets))}
+		m.types[fact] = t
+	}
+	return t
+}
+
+func (m *Metrics) start(fact string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.metrics(fact)
+	t.started++
+	t.inflight++
+}
+
+func (m *Metrics) done(fact string, start time.Time, o outcome) {
+	d := time.Since(start).Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.metrics(fact)
+	t.inflight--
+	t.outcomes[o]++
+
+	// The deferred jobs didn't run, their duration is left out.
+	if o == outcomeDeferred {
+		return
+	}
+
+	t.count++
+	t.sum += d
+
+	for i, le := range m.Buckets {
+		if d <= le {
+			t.buckets[i]++
+		}
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	m.mu.Lock()
+	facts := make([]string, 0, len(m.types))
+	for fact := range m.types {
+		facts = append(facts, fact)
+	}
+	sort.Strings(facts)
+
+	counters := []struct {
+		name, help string
+		value      func(*typeMetrics) uint64
+	}{
+		{"jobs_started_total", "Jobs started.", func(t *typeMetrics) uint64 { return t.started }},
+		{"jobs_succeeded_total", "Jobs succeeded.", func(t *typeMetrics) uint64 { return t.outcomes[outcomeSucceeded] }},
+		{"jobs_failed_total", "Jobs failed.", func(t *typeMetrics) uint64 { return t.outcomes[outcomeFailed] }},
+		{"jobs_panicked_total", "Jobs panicked.", func(t *typeMetrics) uint64 { return t.outcomes[outcomePanicked] }},
+		{"jobs_deferred_total", "Jobs deferred.", func(t *typeMetrics) uint64 { return t.outcomes[outcomeDeferred] }},
+	}
+
+	for _, c := range counters {
+		m.header(&b, c.name, c.help, "counter")
+		for _, fact := range facts {
+			fmt.Fprintf(&b, "%s_%s{type=%s} %d\n", m.Namespace, c.name, quote(fact), c.value(m.types[fact]))
+		}
+	}
+
+	m.header(&b, "jobs_in_flight", "Jobs running.", "gauge")
+	for _, fact := range facts {
+		fmt.Fprintf(&b, "%s_jobs_in_flight{type=%s} %d\n", m.Namespace, quote(fact), m.types[fact].inflight)
+	}
+
+	m.header(&b, "job_duration_seconds", "Jobs duration.", "histogram")
+	for _, fact := range facts {
+		t := m.types[fact]
+		for i, le := range m.Buckets {
+			fmt.Fprintf(&b, "%s_job_duration_seconds_bucket{type=%s,le=%q} %d\n",
+				m.Namespace, quote(fact), formatFloat(le), t.buckets[i])
+		}
+		fmt.Fprintf(&b, "%s_job_duration_seconds_bucket{type=%s,le=\"+Inf\"} %d\n", m.Namespace, quote(fact), t.count)
+		fmt.Fprintf(&b, "%s_job_duration_seconds_sum{type=%s} %s\n", m.Namespace, quote(fact), formatFloat(t.sum))
+		fmt.Fprintf(&b, "%s_job_duration_seconds_count{type=%s} %d\n", m.Namespace, quote(fact), t.count)
+	}
+
+	names := make([]string, 0, len(m.pools))
+	for name := range m.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pools := make([]*Pool, len(names))
+	for i, name := range names {
+		pools[i] = m.pools[name]
+	}
+	m.mu.Unlock()
+
+	// The pools are sampled without holding the lock,
+	// sizing a queue may need a network round trip.
+	if len(pools) > 0 {
+		stats := make([]PoolStats, len(pools))
+		for i, p := range pools {
+			stats[i] = p.Stats()
+		}
+
+		m.header(&b, "workers", "Pool workers by state.", "gauge")
+		for i, name := range names {
+			fmt.Fprintf(&b, "%s_workers{pool=%s,state=\"busy\"} %d\n", m.Namespace, quote(name), stats[i].Busy)
+			fmt.Fprintf(&b, "%s_workers{pool=%s,state=\"idle\"} %d\n", m.Namespace, quote(name), stats[i].Idle)
+		}
+
+		m.header(&b, "queue_jobs", "Queue jobs by state.", "gauge")
+		for i, name := range names {
+			ready, failed, err := pools[i].queue.Size()
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&b, "%s_queue_jobs{pool=%s,state=\"ready\"} %d\n", m.Namespace, quote(name), ready)
+			fmt.Fprintf(&b, "%s_queue_jobs{pool=%s,state=\"failed\"} %d\n", m.Namespace, quote(name), failed)
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// header writes the metric help and type lines.
+func (m *Metrics) header(b *strings.Builder, name, help, typ string) {
+	fmt.Fprintf(b, "# HELP %s_%s %s\n", m.Namespace, name, help)
+	fmt.Fprintf(b, "# TYPE %s_%s %s\n", m.Namespace, name, typ)
+}
+
+// quote returns the escaped label value.
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// formatFloat formats the value as expected by Prometheus.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,113 @@
+package worker_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetWorkers(2),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+	pool.Add(&tickJob{})
+	pool.Add(&badJob{})
+
+	metrics := worker.NewMetrics()
+	metrics.Watch("default", pool)
+	pool.Use(metrics)
+
+	for _, j := range []worker.Job{&tickJob{}, &tickJob{}, &badJob{}} {
+		if err := q.Put(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go pool.Run(ctx)
+
+	want := []string{
+		`worker_jobs_started_total{type="tickJob"} 2`,
+		`worker_jobs_succeeded_total{type="tickJob"} 2`,
+		`worker_jobs_panicked_total{type="badJob"} 1`,
+		`worker_jobs_in_flight{type="tickJob"} 0`,
+		`worker_job_duration_seconds_bucket{type="tickJob",le="+Inf"} 2`,
+		`worker_job_duration_seconds_count{type="badJob"} 1`,
+		`worker_workers{pool="default",state="idle"} 2`,
+		`worker_queue_jobs{pool="default",state="failed"} 1`,
+	}
+
+	// Wait until all the jobs are recorded.
+	var body string
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		w := httptest.NewRecorder()
+		metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+		b, _ := io.ReadAll(w.Body)
+		if body = string(b); containsAll(body, want) {
+			break
+		}
+	}
+
+	for _, line := range want {
+		if !strings.Contains(body, line) {
+			t.Errorf("expecting %q in:\n%s", line, body)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		<-ticks
+	}
+}
+
+// containsAll reports whether s contains all the lines.
+func containsAll(s string, lines []string) bool {
+	for _, line := range lines {
+		if !strings.Contains(s, line) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMetricsDeferred(t *testing.T) {
+	metrics := worker.NewMetrics(func(m *worker.Metrics) {
+		m.Buckets = []float64{1, 0.1, 1}
+	})
+
+	sw := worker.NewStatusWriter()
+	metrics.Exec(context.Background(), sw, "napJob", nil, func(ctx context.Context, sw worker.StatusWriter, fact string, args *worker.Args) {
+		sw.Set(worker.NewDeferError(time.Second))
+	})
+
+	var b strings.Builder
+	if _, err := metrics.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	body := b.String()
+
+	// The deferred jobs are counted without duration.
+	want := []string{
+		`worker_jobs_deferred_total{type="napJob"} 1`,
+		`worker_job_duration_seconds_count{type="napJob"} 0`,
+		`worker_job_duration_seconds_bucket{type="napJob",le="0.1"} 0` + "\n" +
+			`worker_job_duration_seconds_bucket{type="napJob",le="1"} 0` + "\n" +
+			`worker_job_duration_seconds_bucket{type="napJob",le="+Inf"} 0`,
+	}
+
+	for _, line := range want {
+		if !strings.Contains(body, line) {
+			t.Errorf("expecting %q in:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateStore stores the token buckets used by RateLimit, the limits
+// are local to the process unless the store is shared between
+// processes (see RedisRateStore).
+type RateStore interface {
+	// Take takes a token from the key bucket refilled with rate
+	// tokens per second up to burst, it returns the delay until
+	// a token is available if the bucket is empty.
+	Take(key string, rate float64, burst int) (time.Duration, error)
+}
+
+// RateSweepInterval is the minimum delay between the sweeps
+// of the idle buckets of a MemoryRateStore.
+var RateSweepInterval time.Duration = 1 * time.Minute
+
+// bucket represents a token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // Refill time, the bucket is idle afterwards.
+}
+
+// MemoryRateStore represents a token buckets store local to the
+// process, the refilled buckets are dropped to keep the store small.
+type MemoryRateStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time // Last idle buckets sweep.
+}
+
+// NewMemoryRateStore returns a MemoryRateStore instance.
+func NewMemoryRateStore() RateStore {
+	return &MemoryRateStore{buckets: map[string]*bucket{}}
+}
+
+// Take takes a token from the key bucket.
+func (s *MemoryRateStore) Take(key string, rate float64, burst int) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rate <= 0 || burst < 1 {
+		return 0, NewErrorFmt("bad rate: %v (burst %v)", rate, burst)
+	}
+
+	now := time.Now()
+	if now.Sub(s.swept) >= RateSweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens >= 1 {
+		b.tokens--
+	} else {
+		wait = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	return wait, nil
+}
+
+// sweep drops the refilled buckets, they are equivalent
+// to the new buckets.
+func (s *MemoryRateStore) sweep(now time.Time) {
+	for k, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, k)
+		}
+	}
+	s.swept = now
+}
+
+// Len returns the number of buckets.
+func (s *MemoryRateStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+// RedisRateStore represents a token buckets store shared through
+// Redis, each bucket is a key holding its theoretical arrival time
+// (GCRA) which expires once the bucket is refilled. The processes
+// sharing the store must have synchronized clocks.
+type RedisRateStore struct {
+	Host     string // Redis host.
+	Port     string // Redis port.
+	Password string // Redis password.
+	Prefix   string // Keys prefix.
+	Retries  int    // Attempts of the updates conflicting with other processes.
+
+	conn *redisConn
+}
+
+// NewRedisRateStore returns a store instance using custom options.
+func NewRedisRateStore(opts ...func(*RedisRateStore)) (RateStore, error) {
+	s := &RedisRateStore{
+		Host:    RedisHost,
+		Port:    RedisPort,
+		Prefix:  "worker:rate",
+		Retries: 5,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	conn, err := newRedisConn(net.JoinHostPort(s.Host, s.Port), s.Password, RedisIOTimeout)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+
+	return s, nil
+}
+
+// Take takes a token from the key bucket.
+func (s *RedisRateStore) Take(key string, rate float64, burst int) (time.Duration, error) {
+	if rate <= 0 || burst < 1 {
+		return 0, NewErrorFmt("bad rate: %v (burst %v)", rate, burst)
+	}
+
+	k := s.Prefix + ":" + key
+	interval := float64(time.Second) / rate
+
+	for i := 0; i < max(s.Retries, 1); i++ {
+		var wait time.Duration
+		now := time.Now().UnixNano()
+
+		_, err := s.conn.watch([]interface{}{k}, [][]interface{}{{"GET", k}}, func(v []interface{}) ([][]interface{}, error) {
+			// The bucket is full if the arrival time is past.
+			tat := now
+			if b, ok := v[0].([]byte); ok {
+				if n, err := strconv.ParseInt(string(b), 10, 64); err == nil && n > now {
+					tat = n
+				}
+			}
+
+			next := tat + int64(interval)
+			if at := next - int64(float64(burst)*interval); at > now {
+				wait = time.Duration(at - now)
+				return nil, nil
+			}
+
+			ttl := time.Duration(next - now).Milliseconds()
+			return [][]interface{}{{"SET", k, next, "PX", ttl + 1}}, nil
+		})
+
+		if err != errRedisAborted {
+			return wait, err
+		}
+	}
+
+	return 0, NewErrorFmt("rate limit %v: too many conflicts", key)
+}
+
+// RateLimit throttles the jobs using a token bucket per key, the
+// throttled jobs are deferred until a token becomes available.
+type RateLimit struct {
+	Rate   float64                              // Tokens per second.
+	Burst  int                                  // Bucket size.
+	Key    func(fact string, args *Args) string // Bucket key, empty keys aren't limited.
+	Store  RateStore                            // Buckets store, local to the process by default.
+	Jitter float64                              // Randomization factor of the defer delay in [0, 1].
+	Logger *slog.Logger                         // Failures logger, the pool logger if nil.
+}
+
+// NewRateLimit returns a middleware limiting each job type
+// to rate jobs per second with bursts of up to burst jobs,
+// the rate must be positive and the burst at least 1.
+func NewRateLimit(rate float64, burst int, opts ...func(*RateLimit)) (*RateLimit, error) {
+	r := &RateLimit{
+		Rate:   rate,
+		Burst:  burst,
+		Key:    func(fact string, args *Args) string { return fact },
+		Store:  NewMemoryRateStore(),
+		Jitter: 0.2,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.Rate <= 0 || math.IsInf(r.Rate, 0) || math.IsNaN(r.Rate) {
+		return nil, NewErrorFmt("bad rate: %v", r.Rate)
+	}
+
+	if r.Burst < 1 {
+		return nil, NewErrorFmt("bad burst: %v", r.Burst)
+	}
+
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return nil, NewErrorFmt("bad jitter: %v", r.Jitter)
+	}
+
+	return r, nil
+}
+
+// RateLimitByArg returns a key function limiting each
+// job type separately for each value of the argument.
+func RateLimitByArg(name string) func(fact string, args *Args) string {
+	return func(fact string, args *Args) string {
+		v, err := args.Get(name).MarshalJSON()
+		if err != nil {
+			return fact
+		}
+		return fact + ":" + string(v)
+	}
+}
+
+func (r *RateLimit) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner) {
+	key := r.Key(fact, args)
+	if key == "" {
+		next(ctx, sw, fact, args)
+		return
+	}
+
+	wait, err := r.Store.Take("ratelimit:"+key, r.Rate, r.Burst)
+	if err != nil {
+		loggerFromContext(ctx, r.Logger).Error("Rate limit failure", "key", key, "error", err)
+		sw.Set(err)
+		return
+	}
+
+	if wait > 0 {
+		// Spread the throttled jobs, they would be reserved at once.
+		wait += time.Duration(float64(wait) * r.Jitter * rand.Float64())
+		sw.Set(NewDeferError(wait))
+		return
+	}
+
+	next(ctx, sw, fact, args)
+}
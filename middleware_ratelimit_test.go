@@ -0,0 +1,145 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+var ticks chan time.Time = make(chan time.Time, 10)
+
+// tickJob represents a job reporting its run time.
+type tickJob struct{}
+
+func (j *tickJob) Make(args *worker.Args) (worker.Job, error) { return &tickJob{}, nil }
+
+func (j *tickJob) Run() error {
+	ticks <- time.Now()
+	return nil
+}
+
+func TestRateStore(t *testing.T) {
+	host, port := newRedisServer(t).addr()
+	redis, err := worker.NewRedisRateStore(func(s *worker.RedisRateStore) {
+		s.Host, s.Port = host, port
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]worker.RateStore{
+		"memory": worker.NewMemoryRateStore(),
+		"redis":  redis,
+	} {
+		for i := 0; i < 2; i++ {
+			if wait, err := s.Take("key", 10, 2); err != nil || wait != 0 {
+				t.Fatalf("%v: expecting a token, got %v (%v)", name, wait, err)
+			}
+		}
+
+		wait, err := s.Take("key", 10, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if wait <= 0 || wait > 100*time.Millisecond {
+			t.Errorf("%v: expecting to wait up to %v, got %v", name, 100*time.Millisecond, wait)
+		}
+
+		// Buckets are independent.
+		if wait, _ := s.Take("other", 10, 2); wait != 0 {
+			t.Errorf("%v: expecting a token, got %v", name, wait)
+		}
+
+		// The bucket is refilled at rate.
+		time.Sleep(wait)
+		if wait, _ := s.Take("key", 10, 2); wait != 0 {
+			t.Errorf("%v: expecting a token, got %v", name, wait)
+		}
+	}
+}
+
+func TestMemoryRateStoreSweep(t *testing.T) {
+	defer func(d time.Duration) { worker.RateSweepInterval = d }(worker.RateSweepInterval)
+	worker.RateSweepInterval = 0
+
+	s := worker.NewMemoryRateStore()
+	if _, err := s.Take("idle", 100, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The idle bucket is refilled after 10ms.
+	time.Sleep(20 * time.Millisecond)
+	if _, err := s.Take("busy", 100, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.(*worker.MemoryRateStore).Len(); n != 1 {
+		t.Errorf("expecting %v buckets, got %v", 1, n)
+	}
+}
+
+func TestNewRateLimit(t *testing.T) {
+	for _, c := range []struct {
+		rate   float64
+		burst  int
+		jitter float64
+	}{{0, 1, 0}, {-1, 1, 0}, {1, 0, 0}, {1, 1, -1}, {1, 1, 2}} {
+		jitter := func(r *worker.RateLimit) { r.Jitter = c.jitter }
+		if _, err := worker.NewRateLimit(c.rate, c.burst, jitter); err == nil {
+			t.Errorf("expecting error for rate %v, burst %v, jitter %v", c.rate, c.burst, c.jitter)
+		}
+	}
+}
+
+func TestPoolRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+	pool.Add(&tickJob{})
+	limit, err := worker.NewRateLimit(20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Use(limit)
+
+	for i := 0; i < 3; i++ {
+		if err := q.Put(&tickJob{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go pool.Run(ctx)
+
+	var first, last time.Time
+	for i := 0; i < 3; i++ {
+		select {
+		case last = <-ticks:
+			if i == 0 {
+				first = last
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expecting %v runs, got %v", 3, i)
+		}
+	}
+
+	if d := last.Sub(first); d < 90*time.Millisecond {
+		t.Errorf("expecting throttled runs, got 3 runs in %v", d)
+	}
+
+	_, failed, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if failed != 0 {
+		t.Errorf("expecting failed to be %v, got %v", 0, failed)
+	}
+}
@@ -1,13 +1,14 @@
 package worker
 
 import (
+	"context"
 	"log"
 	"os"
 	"runtime"
 )
 
 type Recovery struct {
-	Logger    *log.Logger
+	Logger    *log.Logger // Panics logger, nil disables logging.
 	StackAll  bool
 	StackSize int
 }
@@ -20,18 +21,20 @@ func NewRecovery() *Recovery {
 	}
 }
 
-func (r *Recovery) Exec(sw StatusWriter, fact string, args *Args, next JobRunner) {
+func (r *Recovery) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner) {
 	defer func() {
 		if err := recover(); err != nil {
 			jinfo := fact + " " + args.String()
 			stack := make([]byte, r.StackSize)
 			stack = stack[:runtime.Stack(stack, r.StackAll)]
 
-			f := "%s: PANIC: %s\n%s"
-			r.Logger.Printf(f, jinfo, err, stack)
-			sw.Set(err)
+			if r.Logger != nil {
+				f := "%s: PANIC: %s\n%s"
+				r.Logger.Printf(f, jinfo, err, stack)
+			}
+			sw.Set(newPanicFailure(err, stack))
 		}
 	}()
 
-	next(sw, fact, args)
+	next(ctx, sw, fact, args)
 }
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// RedactedValue replaces the redacted arguments in the logs.
+const RedactedValue = "[REDACTED]"
+
+// StructuredLogger logs the jobs lifecycle as structured key/values
+// (job_id, type, attempt, duration, status, error), it's placed before
+// Recovery to log the panics (see StructuredStack).
+type StructuredLogger struct {
+	Logger *slog.Logger // Destination logger.
+	Args   bool         // Log the job arguments.
+	Redact []string     // Top level arguments replaced by RedactedValue.
+}
+
+// NewStructuredLogger returns a structured logging middleware using custom options.
+func NewStructuredLogger(l *slog.Logger, opts ...func(*StructuredLogger)) *StructuredLogger {
+	s := &StructuredLogger{
+		Logger: l,
+		Args:   true,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// RedactArgs configures the arguments hidden from the logs.
+func RedactArgs(names ...string) func(*StructuredLogger) {
+	return func(s *StructuredLogger) {
+		s.Redact = append(s.Redact, names...)
+	}
+}
+
+// StructuredStack is used to configure the default middleware
+// logging with slog (middlewares: StructuredLogger, Recovery).
+func StructuredStack(l *slog.Logger) []Handler {
+	r := NewRecovery()
+	r.Logger = nil
+
+	return []Handler{NewStructuredLogger(l), r}
+}
+
+func (s *StructuredLogger) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner) {
+	attrs := []any{"type", fact}
+	if msg, ok := MessageFromContext(ctx); ok {
+		attrs = messageAttrs(msg)
+	}
+
+	if s.Args {
+		attrs = append(attrs, "args", s.redact(args))
+	}
+
+	start := time.Now()
+	s.Logger.DebugContext(ctx, "Job started", attrs...)
+
+	next(ctx, sw, fact, args)
+
+	attrs = append(attrs, "duration", time.Since(start))
+
+	var derr *DeferError
+	var f *Failure
+
+	switch err := sw.Get(); {
+	case err == nil:
+		s.Logger.InfoContext(ctx, "Job completed", append(attrs, "status", "ok")...)
+	case errors.As(err, &derr):
+		s.Logger.InfoContext(ctx, "Job completed", append(attrs, "status", "deferred", "delay", derr.Delay)...)
+	case errors.As(err, &f) && f.Panic:
+		s.Logger.ErrorContext(ctx, "Job completed", append(attrs, "status", "panicked", "error", f.Err, "stack", f.Stack)...)
+	default:
+		s.Logger.ErrorContext(ctx, "Job completed", append(attrs, "status", "failed", "error", err)...)
+	}
+}
+
+// redact returns the arguments with the redacted values replaced.
+func (s *StructuredLogger) redact(args *Args) string {
+	if len(s.Redact) == 0 {
+		return args.String()
+	}
+
+	m, err := args.Map()
+	if err != nil {
+		return args.String()
+	}
+
+	hidden := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		hidden[k] = v
+	}
+
+	for _, name := range s.Redact {
+		if _, ok := hidden[name]; ok {
+			hidden[name] = RedactedValue
+		}
+	}
+
+	body, err := json.Marshal(hidden)
+	if err != nil {
+		return "json.Marshal: " + err.Error()
+	}
+	return string(body)
+}
+
+// messageAttrs returns the key/values identifying the message, the
+// backend ID is used for the messages enqueued without metadata.
+func messageAttrs(msg Message, attrs ...any) []any {
+	id := msg.Meta().ID
+	if id == "" {
+		id = messageID(msg)
+	}
+
+	base := []any{"job_id", id, "type", msg.Type(), "attempt", msg.Attempts() + 1}
+	return append(base, attrs...)
+}
+
+// messageID returns the backend ID of the message.
+func messageID(msg Message) string {
+	if m, ok := msg.(interface{ ID() string }); ok {
+		return m.ID()
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+package worker_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+// syncBuffer represents a buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// completed waits for the job completed entry and returns it.
+func completed(t *testing.T, out *syncBuffer) string {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		for _, s := range strings.Split(out.String(), "\n") {
+			if strings.Contains(s, `"Job completed"`) {
+				return s
+			}
+		}
+	}
+
+	t.Fatalf("expecting job completed entry, got %q", out.String())
+	return ""
+}
+
+func TestStructuredLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out syncBuffer
+	l := slog.New(slog.NewJSONHandler(&out, nil))
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetLogger(l),
+		worker.SetSignalPolicy(worker.NoSignals),
+		worker.SetMiddleware(
+			worker.NewStructuredLogger(l, worker.RedactArgs("To")),
+			worker.NewRecovery(),
+		),
+	)
+	pool.Add(&mailJob{})
+
+	if err := q.Put(&mailJob{To: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	go pool.Run(ctx)
+
+	line := completed(t, &out)
+
+	var entry struct {
+		JobID   string `json:"job_id"`
+		Type    string `json:"type"`
+		Attempt int    `json:"attempt"`
+		Status  string `json:"status"`
+		Args    string `json:"args"`
+	}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.JobID == "" || entry.Type != "mailJob" || entry.Attempt != 1 || entry.Status != "ok" {
+		t.Errorf("expecting mailJob attempt 1 ok with job_id, got %+v", entry)
+	}
+
+	if strings.Contains(out.String(), "a@example.com") || !strings.Contains(entry.Args, worker.RedactedValue) {
+		t.Errorf("expecting redacted args, got %q", entry.Args)
+	}
+}
+
+func TestStructuredLoggerDefault(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out syncBuffer
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetLogger(slog.New(slog.NewJSONHandler(&out, nil))),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+	pool.Add(&mailJob{})
+
+	if err := q.Put(&mailJob{To: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	go pool.Run(ctx)
+
+	// The default stack logs the jobs with the pool logger.
+	if line := completed(t, &out); !strings.Contains(line, `"status":"ok"`) {
+		t.Errorf("expecting ok status, got %q", line)
+	}
+}
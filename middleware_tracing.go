@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the message header carrying the W3C trace context.
+const TraceparentHeader = "traceparent"
+
+// SpanContext identifies a span, it's propagated between
+// processes using the W3C traceparent format.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent returns the span context in the W3C traceparent format.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, NewErrorFmt("bad traceparent: %q", s)
+	}
+
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, NewErrorFmt("bad traceparent: %q", s)
+	}
+
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, NewErrorFmt("bad traceparent: %q", s)
+	}
+
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, NewErrorFmt("bad traceparent: %q", s)
+	}
+
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, NewErrorFmt("bad traceparent: %q", s)
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return sc, NewErrorFmt("bad traceparent: %q", s)
+	}
+	return sc, nil
+}
+
+type spanKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying the span context.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by ctx.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// InjectTrace returns the job carrying the span context of ctx,
+// the job is returned unchanged if ctx carries no span context.
+func InjectTrace(ctx context.Context, j Job) Job {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return j
+	}
+	return WithHeaders(j, map[string]string{TraceparentHeader: sc.Traceparent()})
+}
+
+// Span represents a job run.
+type Span struct {
+	Name       string            // Job type.
+	Context    SpanContext       // Span identity.
+	Parent     SpanContext       // Enqueuing span, invalid for root spans.
+	Start      time.Time         // Run start time.
+	End        time.Time         // Run end time.
+	Attributes map[string]string // Span attributes.
+	Error      string            // Failure reason, empty on success.
+}
+
+// SpanExporter sends the finished spans to a tracing backend.
+type SpanExporter interface {
+	ExportSpan(*Span) error
+}
+
+// MemoryExporter represents an exporter keeping the spans
+// in memory, this exporter is used mainly for unit tests.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// NewMemoryExporter returns a MemoryExporter instance.
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// ExportSpan stores the span.
+func (e *MemoryExporter) ExportSpan(s *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+	return nil
+}
+
+// Spans returns the exported spans.
+func (e *MemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// Tracing opens a span around each job run, the span continues the
+// trace of the enqueuing process if the message carries a traceparent
+// header (see InjectTrace).
+type Tracing struct {
+	Exporter SpanExporter
+	Logger   *slog.Logger // Failures logger, the pool logger if nil.
+}
+
+// NewTracing returns a tracing middleware sending the spans to the exporter.
+func NewTracing(exporter SpanExporter) *Tracing {
+	return &Tracing{Exporter: exporter}
+}
+
+func (t *Tracing) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner) {
+	span := &Span{
+		Name:       fact,
+		Start:      time.Now(),
+		Attributes: map[string]string{"job.type": fact},
+	}
+
+	if meta := MetaFromContext(ctx); meta != nil && meta.ID != "" {
+		span.Attributes["job.id"] = meta.ID
+	}
+
+	if h := HeadersFromContext(ctx)[TraceparentHeader]; h != "" {
+		if parent, err := ParseTraceparent(h); err == nil {
+			span.Parent = parent
+		}
+	}
+
+	span.Context = newSpanContext(span.Parent)
+
+	// A panicking job unwinds through the middleware
+	// when it's placed after Recovery.
+	done := false
+	defer func() {
+		if !done {
+			span.Error = "panic"
+			t.finish(ctx, span)
+		}
+	}()
+
+	next(ContextWithSpanContext(ctx, span.Context), sw, fact, args)
+	done = true
+
+	if err := sw.Get(); err != nil {
+		span.Error = err.Error()
+	}
+	t.finish(ctx, span)
+}
+
+// finish ends and exports the span if it's sampled.
+func (t *Tracing) finish(ctx context.Context, span *Span) {
+	span.End = time.Now()
+	if !span.Context.Sampled {
+		return
+	}
+
+	if err := t.Exporter.ExportSpan(span); err != nil {
+		loggerFromContext(ctx, t.Logger).Error("Export failure", "type", span.Name, "error", err)
+	}
+}
+
+// newSpanContext returns a child span context of parent,
+// a new sampled trace is started if parent is invalid.
+func newSpanContext(parent SpanContext) SpanContext {
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = true
+	}
+	rand.Read(sc.SpanID[:])
+	return sc
+}
@@ -0,0 +1,124 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	h := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sc, err := worker.ParseTraceparent(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sc.Sampled || sc.Traceparent() != h {
+		t.Errorf("expecting %q, got %q", h, sc.Traceparent())
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, err := worker.ParseTraceparent(bad); err == nil {
+			t.Errorf("expecting error for %q", bad)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exporter := worker.NewMemoryExporter()
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+	pool.Add(&tickJob{})
+	pool.Use(worker.NewTracing(exporter))
+
+	// The job is enqueued while handling a traced request.
+	parent, err := worker.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rctx := worker.ContextWithSpanContext(context.Background(), parent)
+	if err := q.Put(worker.InjectTrace(rctx, &tickJob{})); err != nil {
+		t.Fatal(err)
+	}
+
+	go pool.Run(ctx)
+	<-ticks
+
+	var spans []*worker.Span
+	for deadline := time.Now().Add(time.Second); len(spans) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		spans = exporter.Spans()
+	}
+
+	if len(spans) != 1 {
+		t.Fatalf("expecting %v spans, got %v", 1, len(spans))
+	}
+
+	span := spans[0]
+	if span.Name != "tickJob" || span.Error != "" {
+		t.Errorf("expecting successful tickJob span, got %q (%q)", span.Name, span.Error)
+	}
+
+	if span.Parent != parent || span.Context.TraceID != parent.TraceID {
+		t.Errorf("expecting span to continue trace %q, got %q", parent.Traceparent(), span.Context.Traceparent())
+	}
+
+	if span.Context.SpanID == parent.SpanID || span.End.Before(span.Start) {
+		t.Errorf("expecting a new finished span, got %+v", span)
+	}
+}
+
+// failingExporter represents an exporter rejecting every span.
+type failingExporter struct{}
+
+func (failingExporter) ExportSpan(*worker.Span) error { return errors.New("unavailable") }
+
+func TestTracingLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out syncBuffer
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetLogger(slog.New(slog.NewJSONHandler(&out, nil))),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+	pool.Add(&tickJob{})
+	pool.Use(worker.NewTracing(failingExporter{}))
+
+	if err := q.Put(&tickJob{}); err != nil {
+		t.Fatal(err)
+	}
+
+	go pool.Run(ctx)
+	<-ticks
+
+	// The failures are logged by the pool logger.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if strings.Contains(out.String(), `"msg":"Export failure"`) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expecting export failure entry, got %q", out.String())
+}
@@ -0,0 +1,264 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// Polling represents the order used by MultiQueue to poll its queues.
+type Polling int
+
+const (
+	StrictPolling   Polling = iota // Poll the queues in order, lower queues wait for the upper ones.
+	WeightedPolling                // Poll the queues in weighted round-robin order.
+)
+
+// QueueNamer is implemented by the jobs declaring their target queue.
+type QueueNamer interface {
+	QueueName() string
+}
+
+// NamedQueue represents a queue consumed by MultiQueue.
+type NamedQueue struct {
+	Name   string // Queue name (see QueueNamer).
+	Queue  Queue  // Underlying queue.
+	Weight int    // Polling weight, used by WeightedPolling.
+
+	current int // Smooth round-robin state.
+}
+
+// multiMessage represents a message remembering its source queue.
+type multiMessage struct {
+	Message
+	queue *NamedQueue
+}
+
+// ID returns the backend ID of the message.
+func (m *multiMessage) ID() string { return messageID(m.Message) }
+
+// QueueName returns the name of the source queue.
+func (m *multiMessage) QueueName() string {
+	return m.queue.Name
+}
+
+// decodeErr returns the decoding failure of the message.
+func (m *multiMessage) decodeErr() error {
+	if u, ok := m.Message.(undecodable); ok {
+		return u.decodeErr()
+	}
+	return nil
+}
+
+// MultiQueue represents a queue consuming several named queues, jobs
+// are routed to the queue declared by QueueName or to the first queue.
+// Each empty queue polled costs its reserve timeout (e.g. RedisTimeout),
+// lower the timeouts when consuming blocking backends.
+type MultiQueue struct {
+	Queues  []*NamedQueue // Queues by priority.
+	Polling Polling       // Polling order.
+
+	mu sync.Mutex
+}
+
+// NewMultiQueue returns a queue instance using custom options.
+func NewMultiQueue(opts ...func(*MultiQueue)) (Queue, error) {
+	q := &MultiQueue{
+		Polling: StrictPolling,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	if len(q.Queues) == 0 {
+		return nil, NewError("no queues")
+	}
+
+	names := map[string]bool{}
+	for _, nq := range q.Queues {
+		if names[nq.Name] {
+			return nil, NewErrorFmt("queue %q exists already", nq.Name)
+		}
+		names[nq.Name] = true
+
+		if nq.Weight < 1 {
+			nq.Weight = 1
+		}
+	}
+
+	return q, nil
+}
+
+// Queue returns the named queue, nil is returned if it doesn't exist.
+func (q *MultiQueue) Queue(name string) Queue {
+	if nq := q.lookup(name); nq != nil {
+		return nq.Queue
+	}
+	return nil
+}
+
+func (q *MultiQueue) lookup(name string) *NamedQueue {
+	for _, nq := range q.Queues {
+		if nq.Name == name {
+			return nq
+		}
+	}
+	return nil
+}
+
+// route returns the target queue of the job.
+func (q *MultiQueue) route(j Job) (Queue, error) {
+	v, ok := unwrapJob(j).(QueueNamer)
+	if !ok {
+		return q.Queues[0].Queue, nil
+	}
+
+	nq := q.lookup(v.QueueName())
+	if nq == nil {
+		return nil, NewErrorFmt("bad queue: %v", v.QueueName())
+	}
+	return nq.Queue, nil
+}
+
+// Put puts the job in its target queue.
+func (q *MultiQueue) Put(j Job) error {
+	target, err := q.route(j)
+	if err != nil {
+		return err
+	}
+	return target.Put(j)
+}
+
+// PutIn puts the job in its target queue, the
+// job becomes ready after the delay elapses.
+func (q *MultiQueue) PutIn(j Job, delay time.Duration) error {
+	target, err := q.route(j)
+	if err != nil {
+		return err
+	}
+	return target.PutIn(j, delay)
+}
+
+// PutAt puts the job in its target queue, the job
+// becomes ready at the specified time.
+func (q *MultiQueue) PutAt(j Job, t time.Time) error {
+	target, err := q.route(j)
+	if err != nil {
+		return err
+	}
+	return target.PutAt(j, t)
+}
+
+// Get reserves a job polling the queues in the configured order,
+// the first error is returned if all the queues are empty.
+func (q *MultiQueue) Get() (Message, error) {
+	var first error
+	for _, nq := range q.order() {
+		msg, err := nq.Queue.Get()
+		if err == nil {
+			return &multiMessage{Message: msg, queue: nq}, nil
+		}
+
+		if first == nil {
+			first = err
+		}
+	}
+
+	return nil, first
+}
+
+// order returns the queues in polling order, the weighted order
+// starts with the smooth round-robin pick followed by the
+// remaining queues by priority.
+func (q *MultiQueue) order() []*NamedQueue {
+	if q.Polling != WeightedPolling || len(q.Queues) == 1 {
+		return q.Queues
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var best *NamedQueue
+	total := 0
+	for _, nq := range q.Queues {
+		nq.current += nq.Weight
+		total += nq.Weight
+		if best == nil || nq.current > best.current {
+			best = nq
+		}
+	}
+	best.current -= total
+
+	order := []*NamedQueue{best}
+	for _, nq := range q.Queues {
+		if nq != best {
+			order = append(order, nq)
+		}
+	}
+	return order
+}
+
+// unwrap returns the source queue and the underlying message.
+func (q *MultiQueue) unwrap(m Message) (*NamedQueue, Message, error) {
+	env, ok := m.(*multiMessage)
+	if !ok {
+		return nil, nil, NewErrorFmt("bad envelope: %v", m)
+	}
+	return env.queue, env.Message, nil
+}
+
+// Delete deletes a job from its source queue.
+func (q *MultiQueue) Delete(m Message) error {
+	nq, msg, err := q.unwrap(m)
+	if err != nil {
+		return err
+	}
+	return nq.Queue.Delete(msg)
+}
+
+// Release puts the job back in its source queue.
+func (q *MultiQueue) Release(m Message, delay time.Duration) error {
+	nq, msg, err := q.unwrap(m)
+	if err != nil {
+		return err
+	}
+	return nq.Queue.Release(msg, delay)
+}
+
+// Defer puts the job back in its source queue without counting
+// an attempt, the job is released if the queue can't defer it.
+func (q *MultiQueue) Defer(m Message, delay time.Duration) error {
+	nq, msg, err := q.unwrap(m)
+	if err != nil {
+		return err
+	}
+
+	if d, ok := nq.Queue.(Deferrer); ok {
+		return d.Defer(msg, delay)
+	}
+	return nq.Queue.Release(msg, delay)
+}
+
+// Reject rejects the job in its source queue.
+func (q *MultiQueue) Reject(m Message, reason error) error {
+	nq, msg, err := q.unwrap(m)
+	if err != nil {
+		return err
+	}
+	return nq.Queue.Reject(msg, reason)
+}
+
+// Size returns the number of ready and failed jobs of all the queues.
+func (q *MultiQueue) Size() (uint64, uint64, error) {
+	var ready, failed uint64
+	for _, nq := range q.Queues {
+		r, f, err := nq.Queue.Size()
+		if err != nil {
+			return 0, 0, err
+		}
+		ready += r
+		failed += f
+	}
+	return ready, failed, nil
+}
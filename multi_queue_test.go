@@ -0,0 +1,119 @@
+package worker_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vitalie/worker"
+)
+
+// routedJob represents a test job declaring its target queue.
+type routedJob struct {
+	Q string
+}
+
+func (j *routedJob) Make(args *worker.Args) (worker.Job, error) { return &routedJob{}, nil }
+
+func (j *routedJob) Run() error { return nil }
+
+func (j *routedJob) QueueName() string { return j.Q }
+
+// newMultiQueue returns a multi queue consuming memory queues.
+func newMultiQueue(t *testing.T, polling worker.Polling, weights map[string]int, names ...string) worker.Queue {
+	q, err := worker.NewMultiQueue(func(q *worker.MultiQueue) {
+		for _, name := range names {
+			q.Queues = append(q.Queues, &worker.NamedQueue{
+				Name:   name,
+				Queue:  worker.NewMemoryQueue(),
+				Weight: weights[name],
+			})
+		}
+		q.Polling = polling
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestMultiQueueStrict(t *testing.T) {
+	q := newMultiQueue(t, worker.StrictPolling, nil, "critical", "default", "low")
+
+	for _, name := range []string{"low", "default", "critical", "default"} {
+		if err := q.Put(&routedJob{Q: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := q.Put(&routedJob{Q: "missing"}); err == nil {
+		t.Error("expecting bad queue error")
+	}
+
+	for _, want := range []string{"critical", "default", "default", "low"} {
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := msg.(worker.QueueNamer).QueueName(); got != want {
+			t.Errorf("expecting %q, got %q", want, got)
+		}
+
+		if err := q.Reject(msg, errors.New("boom")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := q.Get(); err == nil {
+		t.Error("expecting empty queue")
+	}
+
+	// Rejected jobs are kept by their source queue.
+	_, failed, err := q.(*worker.MultiQueue).Queue("default").Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if failed != 2 {
+		t.Errorf("expecting failed to be %v, got %v", 2, failed)
+	}
+}
+
+func TestMultiQueueWeighted(t *testing.T) {
+	weights := map[string]int{"default": 3, "low": 1}
+	q := newMultiQueue(t, worker.WeightedPolling, weights, "default", "low")
+
+	for i := 0; i < 10; i++ {
+		for _, name := range []string{"default", "low"} {
+			if err := q.Put(&routedJob{Q: name}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[msg.(worker.QueueNamer).QueueName()]++
+
+		if err := q.Delete(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if counts["default"] != 6 || counts["low"] != 2 {
+		t.Errorf("expecting 6 default and 2 low jobs, got %v", counts)
+	}
+
+	ready, _, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ready != 12 {
+		t.Errorf("expecting size to be %v, got %v", 12, ready)
+	}
+}
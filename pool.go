@@ -1,9 +1,11 @@
 package worker
 
 import (
-	"log"
+	"errors"
+	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"context"
@@ -14,30 +16,101 @@ const (
 )
 
 var (
-	DefaultTTR time.Duration = 10 * time.Minute
+	DefaultTTR             time.Duration = 10 * time.Minute
+	DefaultShutdownTimeout time.Duration = 30 * time.Second
+	DefaultDeferDelay      time.Duration = 1 * time.Second
 )
 
+// defaultLogger is the pool logger unless one is set (see SetLogger).
+var defaultLogger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+// FactoryOptions represents the options of a registered job type.
+type FactoryOptions struct {
+	MaxConcurrency int           // Maximum running jobs, unlimited if zero.
+	DeferDelay     time.Duration // Delay of the jobs deferred while saturated.
+	Strict         bool          // Reject unknown arguments of typed jobs (see Register).
+
+	// Payload upgrade functions by source version (see Upgrade).
+	Upgrades map[int]func(*Args) error
+}
+
+// MaxConcurrency limits the number of running jobs of the type,
+// the jobs reserved while the type is saturated are deferred.
+func MaxConcurrency(n int) func(*FactoryOptions) {
+	return func(o *FactoryOptions) {
+		o.MaxConcurrency = n
+	}
+}
+
+// Strict rejects the typed jobs with unknown arguments, the
+// rejected jobs fail permanently (see Register).
+func Strict() func(*FactoryOptions) {
+	return func(o *FactoryOptions) {
+		o.Strict = true
+	}
+}
+
+// Upgrade registers the function migrating the arguments of the
+// payloads having the from version to the next version.
+func Upgrade(from int, fn func(*Args) error) func(*FactoryOptions) {
+	return func(o *FactoryOptions) {
+		if o.Upgrades == nil {
+			o.Upgrades = map[int]func(*Args) error{}
+		}
+		o.Upgrades[from] = fn
+	}
+}
+
+// jobType represents the concurrency state of a job type.
+type jobType struct {
+	opts    FactoryOptions
+	version int // Current payload version.
+	running int
+}
+
 // Pool represents a pool of workers connected to a queue.
 type Pool struct {
-	queue Queue         // input queue
-	count int           // workers count
-	ttr   time.Duration // Time to run.
+	mu        sync.RWMutex  // Guards the options during reload.
+	queue     Queue         // input queue
+	count     int           // workers count
+	ttr       time.Duration // Time to run.
+	retry     RetryPolicy   // Failed jobs retry policy.
+	scheduler *Scheduler    // Recurring jobs scheduler.
+	autoscale *Autoscaler   // Workers count autoscaler, optional.
+	shutdown  time.Duration // In-flight jobs drain timeout.
+	signals   SignalPolicy  // Handled unix signals.
+	reload    []func(*Pool) // Options reapplied on reload.
+	draining  atomic.Bool   // Set while shutting down.
+	finished  atomic.Int64  // Jobs finished while draining.
+	released  atomic.Int64  // Jobs released while draining.
+
+	smu       sync.Mutex           // Guards the workers state.
+	states    map[int]*workerState // Workers by ID.
+	types     map[string]*jobType  // Job types with options.
+	run       *poolRun             // Running pool, nil if stopped.
+	resumed   chan struct{}        // Closed on resume, nil if not paused.
+	observers []Observer           // State transitions observers.
 
 	middleware middleware
 	handlers   []Handler
 	mux        map[string]Factory
-	logger     *log.Logger
+	logger     *slog.Logger
 }
 
 // NewPool returns a new Pool instance.
 func NewPool(opts ...func(*Pool)) *Pool {
 	pool := &Pool{
-		queue:    NewMemoryQueue(),
-		count:    DefaultWorkersCount,
-		ttr:      DefaultTTR,
-		mux:      map[string]Factory{},
-		logger:   log.New(os.Stdout, "[worker] ", 0),
-		handlers: CommonStack(),
+		queue:     NewMemoryQueue(),
+		count:     DefaultWorkersCount,
+		ttr:       DefaultTTR,
+		retry:     NoRetry,
+		scheduler: NewScheduler(),
+		shutdown:  DefaultShutdownTimeout,
+		signals:   DefaultSignalPolicy,
+		states:    map[int]*workerState{},
+		types:     map[string]*jobType{},
+		mux:       map[string]Factory{},
+		logger:    defaultLogger,
 	}
 
 	// Apply options.
@@ -45,6 +118,14 @@ func NewPool(opts ...func(*Pool)) *Pool {
 		opt(pool)
 	}
 
+	// The default stack logs with the pool logger if one is set.
+	if pool.handlers == nil {
+		pool.handlers = CommonStack()
+		if pool.logger != defaultLogger {
+			pool.handlers = StructuredStack(pool.logger)
+		}
+	}
+
 	// Init middleware stack.
 	pool.middleware = pool.build(pool.handlers)
 
@@ -53,20 +134,88 @@ func NewPool(opts ...func(*Pool)) *Pool {
 
 // Add registers a new job factory.
 func (p *Pool) Add(f Factory) error {
+	return p.AddWithOptions(f)
+}
+
+// AddWithOptions registers a new job factory using custom options.
+func (p *Pool) AddWithOptions(f Factory, opts ...func(*FactoryOptions)) error {
 	typ, err := StructType(f)
 	if err != nil {
 		return err
 	}
+	return p.add(typ, f, opts...)
+}
 
+// add registers the factory of the job type.
+func (p *Pool) add(typ string, f Factory, opts ...func(*FactoryOptions)) error {
 	if _, ok := p.mux[typ]; ok {
 		return NewErrorFmt("factory %q exists already", typ)
 	}
+
+	t := &jobType{
+		opts:    FactoryOptions{DeferDelay: DefaultDeferDelay},
+		version: jobVersion(f),
+	}
+	for _, opt := range opts {
+		opt(&t.opts)
+	}
+
+	// The older payloads must be upgradable.
+	for v := 0; v < t.version; v++ {
+		if _, ok := t.opts.Upgrades[v]; !ok {
+			return NewErrorFmt("factory %q: missing upgrade from version %v", typ, v)
+		}
+	}
+
 	p.mux[typ] = f
+	p.types[typ] = t
 	return nil
 }
 
+// acquire reserves a run slot for the job type, the defer
+// delay is returned if the type is saturated.
+func (p *Pool) acquire(typ string) (time.Duration, bool) {
+	p.smu.Lock()
+	defer p.smu.Unlock()
+
+	t, ok := p.types[typ]
+	if !ok || t.opts.MaxConcurrency <= 0 {
+		return 0, true
+	}
+
+	if t.running >= t.opts.MaxConcurrency {
+		return t.opts.DeferDelay, false
+	}
+	t.running++
+	return 0, true
+}
+
+// done frees the run slot of the job type.
+func (p *Pool) done(typ string) {
+	p.smu.Lock()
+	defer p.smu.Unlock()
+
+	if t, ok := p.types[typ]; ok && t.opts.MaxConcurrency > 0 {
+		t.running--
+	}
+}
+
+// Schedule registers a recurring job, the job is enqueued
+// on schedule while the pool is running (see ParseSchedule).
+func (p *Pool) Schedule(spec string, j Job) error {
+	return p.scheduler.Add(spec, j)
+}
+
+// Entries returns the scheduled jobs with their next activation times.
+func (p *Pool) Entries() []Entry {
+	return p.scheduler.Entries()
+}
+
 // Use appends a new middleware to current stack.
 func (p *Pool) Use(h Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	p.handlers = append(p.handlers, h)
 	p.middleware = p.build(p.handlers)
 }
@@ -86,8 +235,8 @@ func (p *Pool) build(hs []Handler) middleware {
 // execute the job without calling next middleware.
 func (p *Pool) last() middleware {
 	return middleware{
-		HandlerFunc(func(sw StatusWriter, fact string, args *Args, next JobRunner) {
-			if err := p.execute(fact, args); err != nil {
+		HandlerFunc(func(ctx context.Context, sw StatusWriter, fact string, args *Args, next JobRunner) {
+			if err := p.execute(ctx, fact, args); err != nil {
 				sw.Set(err)
 			}
 		}),
@@ -95,59 +244,177 @@ func (p *Pool) last() middleware {
 	}
 }
 
-// Run starts processing jobs from the queue.
+// Run starts processing jobs from the queue. Once the context is
+// cancelled or a quit signal is received the pool stops reserving
+// messages and waits for the in-flight jobs to finish, the jobs still
+// running after the shutdown timeout are released back to the queue.
 func (p *Pool) Run(ctx context.Context) error {
 	var wg sync.WaitGroup
 
 	// Handle unix signals.
-	sig := trap()
+	sig, untrap := p.signals.trap()
+	defer untrap()
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	// The run context stops the master and the scheduler,
+	// the jobs context is cancelled once draining times out.
+	rctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	jctx, kill := context.WithCancel(context.WithoutCancel(ctx))
+	defer kill()
 
 	// Fan-out channel.
 	c := make(chan Message)
 
 	// Start workers.
-	wg.Add(p.count + 1)
-	for i := 0; i < p.count; i++ {
+	if a := p.autoscale; a != nil {
+		p.mu.Lock()
+		p.count = min(max(p.count, a.Min), a.Max)
+		p.mu.Unlock()
+	}
+	p.start(&poolRun{ctx: jctx, wg: &wg, in: c})
+
+	// Start the master.
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.master(rctx, c)
+	}()
+
+	// Start the scheduler.
+	go func() {
+		defer wg.Done()
+		p.scheduler.Run(contextWithLogger(rctx, p.logger), p.queue)
+	}()
+
+	// Start the autoscaler.
+	if a := p.autoscale; a != nil {
+		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			p.worker(ctx, c)
+			a.Run(contextWithLogger(rctx, p.logger), p)
 		}()
 	}
 
-	// Start the master.
+	err := p.wait(ctx, sig)
+
+	p.finished.Store(0)
+	p.released.Store(0)
+	p.draining.Store(true)
+	defer p.draining.Store(false)
+
+	p.logger.Info("Draining workers")
+	p.stop()
+	stop()
+
+	done := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		p.master(ctx, c)
+		wg.Wait()
+		close(done)
 	}()
 
-	var err error
 	select {
-	case <-ctx.Done():
-		err = ctx.Err()
-	case <-sig:
-		p.logger.Println("Quit signal received ...")
-		cancel()
+	case <-done:
+	case <-time.After(p.shutdownTimeout()):
+		p.logger.Warn("Shutdown timeout, releasing jobs")
+		kill()
+		<-done
 	}
 
-	p.logger.Println("Stopping workers ...")
-	close(c)
-	wg.Wait()
-	p.logger.Println("Shutdown completed!")
+	p.logger.Info("Shutdown completed",
+		"finished", p.finished.Load(), "released", p.released.Load())
 	return err
 }
 
-// master polls the input queue sending jobs to workers through a blocking channel.
+// wait handles the signals until the context is
+// done or a quit signal is received.
+func (p *Pool) wait(ctx context.Context, sig <-chan os.Signal) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case s := <-sig:
+			switch {
+			case has(p.signals.Quit, s):
+				p.logger.Info("Quit signal received", "signal", s.String())
+				return nil
+			case has(p.signals.Reload, s):
+				p.logger.Info("Reload signal received", "signal", s.String())
+				p.Reload()
+			case has(p.signals.Pause, s):
+				if p.Paused() {
+					p.logger.Info("Pause signal received, resuming", "signal", s.String())
+					p.Resume()
+				} else {
+					p.logger.Info("Pause signal received, pausing", "signal", s.String())
+					p.Pause()
+				}
+			case has(p.signals.Dump, s):
+				p.dump()
+			}
+		}
+	}
+}
+
+// Reload reapplies the reloadable options (see SetReloadable),
+// the running pool is scaled to the reloaded workers count.
+func (p *Pool) Reload() {
+	p.mu.Lock()
+	old := p.count
+	for _, opt := range p.reload {
+		opt(p)
+	}
+	n := p.count
+	p.count = old
+	p.middleware = p.build(p.handlers)
+	p.mu.Unlock()
+
+	if err := p.Scale(n); err != nil {
+		p.logger.Error("Reload failure", "error", err)
+	}
+}
+
+// workers returns the configured workers count.
+func (p *Pool) workers() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.count
+}
+
+// shutdownTimeout returns the current drain timeout.
+func (p *Pool) shutdownTimeout() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.shutdown
+}
+
+// master polls the input queue sending jobs to workers through a blocking
+// channel, the channel is closed once the context is done.
 func (p *Pool) master(ctx context.Context, c chan<- Message) {
+	defer close(c)
+
 	qs := newQueueService(p.queue)
-	var r *response
 	for {
+		var r *response
+
+		// Don't reserve messages while paused.
+		if resumed := p.pausedChan(); resumed != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-resumed:
+			}
+		}
+
+		resp := qs.get()
 		select {
 		case <-ctx.Done():
+			// Return the message reserved meanwhile.
+			if r = <-resp; r.Err == nil {
+				p.release(r.Msg)
+			}
 			return
-		case r = <-qs.get():
+		case r = <-resp:
 		}
 
 		if r.Err != nil {
@@ -157,71 +424,223 @@ func (p *Pool) master(ctx context.Context, c chan<- Message) {
 			return
 		}
 
+		// Return the message reserved while pausing.
+		if p.Paused() {
+			p.postpone(r.Msg, 0)
+			continue
+		}
+
 		select {
 		case <-ctx.Done():
+			p.release(r.Msg)
 			return
 		case c <- r.Msg:
 		}
 	}
 }
 
-// worker executes jobs from the in channel in a separate goroutine.
-func (p *Pool) worker(ctx context.Context, in <-chan Message) {
-	for msg := range in {
-		status := NewStatusWriter()
-		done := make(chan struct{}, 1)
+// process runs a single message, it returns false when
+// the jobs context is done and the worker must quit.
+func (p *Pool) process(ctx context.Context, msg Message) bool {
+	// The undecodable messages are kept as is for inspection.
+	if u, ok := msg.(undecodable); ok && u.decodeErr() != nil {
+		p.reject(msg, u.decodeErr())
+		return true
+	}
 
-		// Start the job in a separate goroutine.
-		go func() {
-			p.Exec(status, msg.Type(), msg.Args())
-			done <- struct{}{}
-		}()
+	status := NewStatusWriter()
+	done := make(chan struct{}, 1)
 
-		ctx, cancel := context.WithTimeout(ctx, p.ttr)
-		defer cancel()
+	// The job context expires after TTR or when
+	// the jobs context is cancelled.
+	p.mu.RLock()
+	ttr := p.ttr
+	p.mu.RUnlock()
 
-		// Wait job completion.
-		select {
-		case <-ctx.Done():
-			if err := p.queue.Reject(msg); err != nil {
-				p.logger.Println("Reject failure:", msg, err)
-			}
-			return
-		case <-done:
-			if status.OK() {
-				if err := p.queue.Delete(msg); err != nil {
-					p.logger.Println("Delete failure:", msg, err)
-				}
-			} else {
-				if err := p.queue.Reject(msg); err != nil {
-					p.logger.Println("Reject failure:", msg, err)
-				}
+	jctx, cancel := context.WithTimeout(ctx, ttr)
+	defer cancel()
+
+	jctx = contextWithLogger(contextWithMessage(jctx, msg), p.logger)
+	if headers := msg.Meta().Headers; len(headers) > 0 {
+		jctx = ContextWithHeaders(jctx, headers)
+	}
+
+	// Start the job in a separate goroutine.
+	start := time.Now()
+	go func() {
+		p.Exec(jctx, status, msg.Type(), msg.Args())
+		done <- struct{}{}
+	}()
+
+	// Wait job completion.
+	select {
+	case <-jctx.Done():
+		if ctx.Err() != nil {
+			p.release(msg)
+			return false
+		}
+		p.fail(msg, NewErrorFmt("ttr: %v", jctx.Err()))
+	case <-done:
+		// Throttled jobs don't count as attempts.
+		var derr *DeferError
+		if errors.As(status.Get(), &derr) {
+			p.postpone(msg, derr.Delay)
+			break
+		}
+
+		if a := p.autoscale; a != nil {
+			a.record(time.Since(start))
+		}
+
+		if status.OK() {
+			if err := p.queue.Delete(msg); err != nil {
+				p.logger.Error("Delete failure", messageAttrs(msg, "error", err)...)
 			}
+		} else {
+			p.fail(msg, status.Get())
 		}
 	}
+
+	if p.draining.Load() {
+		p.finished.Add(1)
+	}
+	return true
+}
+
+// postpone puts the message back to the queue without counting
+// an attempt, the message is released if the queue can't defer it.
+func (p *Pool) postpone(msg Message, delay time.Duration) {
+	var err error
+	if d, ok := p.queue.(Deferrer); ok {
+		err = d.Defer(msg, delay)
+	} else {
+		err = p.queue.Release(msg, delay)
+	}
+
+	if err != nil {
+		p.logger.Error("Defer failure", messageAttrs(msg, "error", err)...)
+	}
+}
+
+// release puts the message interrupted by the shutdown back
+// to the queue, the interruption doesn't count as an attempt.
+func (p *Pool) release(msg Message) {
+	p.released.Add(1)
+	p.postpone(msg, 0)
+}
+
+// fail releases the failed message back to the queue
+// if the retry policy allows it, otherwise rejects it.
+func (p *Pool) fail(msg Message, reason error) {
+	p.mu.RLock()
+	policy := p.retry
+	p.mu.RUnlock()
+
+	// Permanent failures are never retried.
+	var perr *PermanentError
+	if errors.As(reason, &perr) {
+		p.reject(msg, reason)
+		return
+	}
+
+	if r, ok := unwrapFactory(p.mux[msg.Type()]).(Retryable); ok {
+		policy = r.RetryPolicy()
+	}
+
+	if delay, ok := policy.Backoff(msg.Attempts() + 1); ok {
+		if err := p.queue.Release(msg, delay); err != nil {
+			p.logger.Error("Release failure", messageAttrs(msg, "error", err)...)
+		}
+		return
+	}
+
+	p.reject(msg, reason)
+}
+
+// reject marks the message as failed.
+func (p *Pool) reject(msg Message, reason error) {
+	if err := p.queue.Reject(msg, reason); err != nil {
+		p.logger.Error("Reject failure", messageAttrs(msg, "error", err)...)
+	}
 }
 
 // Exec runs the job passing it through the middleware stack.
-func (p *Pool) Exec(sw StatusWriter, fact string, args *Args) {
-	p.middleware.Exec(sw, fact, args)
+func (p *Pool) Exec(ctx context.Context, sw StatusWriter, fact string, args *Args) {
+	p.mu.RLock()
+	m := p.middleware
+	p.mu.RUnlock()
+
+	m.Exec(ctx, sw, fact, args)
 }
 
 // execute runs the job without passing it through the
 // middleware stack.
-func (p *Pool) execute(fact string, args *Args) error {
+func (p *Pool) execute(ctx context.Context, fact string, args *Args) error {
 	f, ok := p.mux[fact]
 	if !ok {
 		return NewErrorFmt("bad type: %v", fact)
 	}
 
+	if msg, ok := MessageFromContext(ctx); ok {
+		var err error
+		if args, err = p.upgrade(fact, msg.Version(), args); err != nil {
+			return err
+		}
+	}
+
 	j, err := f.Make(args)
 	if err != nil {
-		return NewErrorFmt("make: %v", err)
+		return wrapError("make", err)
 	}
 
-	if err := j.Run(); err != nil {
-		return NewErrorFmt("run: %v", err)
+	if c, ok := j.(*contextJob); ok {
+		err = c.job.Run(ctx)
+	} else {
+		err = j.Run()
+	}
+
+	if err != nil {
+		return wrapError("run", err)
 	}
 
 	return nil
 }
+
+// upgrade returns the arguments of a payload having the version
+// migrated to the current version of the job type. A copy is
+// migrated, the message keeps its payload for the retries.
+func (p *Pool) upgrade(fact string, version int, args *Args) (*Args, error) {
+	t, ok := p.types[fact]
+	if !ok || version == t.version {
+		return args, nil
+	}
+
+	// Newer payloads are deferred without counting an attempt,
+	// e.g. during a rolling deploy.
+	if version > t.version {
+		return nil, NewDeferError(t.opts.DeferDelay)
+	}
+
+	body, err := args.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	json, err := toJson(body)
+	if err != nil {
+		return nil, err
+	}
+	args = &Args{&data{json}}
+
+	for v := version; v < t.version; v++ {
+		fn, ok := t.opts.Upgrades[v]
+		if !ok {
+			return nil, NewPermanentError(NewErrorFmt("upgrade: missing version %v", v))
+		}
+
+		if err := fn(args); err != nil {
+			return nil, NewPermanentError(NewErrorFmt("upgrade %v: %v", v, err))
+		}
+	}
+	return args, nil
+}
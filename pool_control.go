@@ -0,0 +1,369 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventKind represents the kind of a pool state transition.
+type EventKind int
+
+const (
+	Paused        EventKind = iota // The pool stopped reserving messages.
+	Resumed                        // The pool resumed reserving messages.
+	Scaled                         // The workers count was changed.
+	WorkerStarted                  // A worker was started.
+	WorkerRetired                  // A worker was retired.
+)
+
+// String returns the event kind name.
+func (k EventKind) String() string {
+	switch k {
+	case Paused:
+		return "paused"
+	case Resumed:
+		return "resumed"
+	case Scaled:
+		return "scaled"
+	case WorkerStarted:
+		return "worker started"
+	case WorkerRetired:
+		return "worker retired"
+	}
+	return "unknown"
+}
+
+// Event represents a pool state transition.
+type Event struct {
+	Kind    EventKind // Transition kind.
+	Worker  int       // Worker ID, for worker events.
+	Workers int       // Configured workers count.
+	Time    time.Time // Transition time.
+}
+
+// Observer is notified of the pool state transitions,
+// observers are called synchronously and must not block.
+type Observer interface {
+	Observe(Event)
+}
+
+// The ObserverFunc type is an adapter to allow the use of
+// ordinary functions as observers.
+type ObserverFunc func(Event)
+
+// Observe calls f(e).
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// Observe registers an observer of the pool state transitions.
+func (p *Pool) Observe(o Observer) {
+	p.smu.Lock()
+	defer p.smu.Unlock()
+	p.observers = append(p.observers, o)
+}
+
+// emit notifies the observers, it must be called without holding smu.
+func (p *Pool) emit(kind EventKind, worker int) {
+	n := p.workers()
+
+	p.smu.Lock()
+	observers := p.observers
+	p.smu.Unlock()
+
+	e := Event{Kind: kind, Worker: worker, Workers: n, Time: time.Now()}
+	for _, o := range observers {
+		o.Observe(e)
+	}
+}
+
+// Pause stops reserving messages, the in-flight jobs keep running.
+func (p *Pool) Pause() {
+	p.smu.Lock()
+	if p.resumed != nil {
+		p.smu.Unlock()
+		return
+	}
+	p.resumed = make(chan struct{})
+	p.smu.Unlock()
+
+	p.emit(Paused, -1)
+}
+
+// Resume resumes reserving messages.
+func (p *Pool) Resume() {
+	p.smu.Lock()
+	if p.resumed == nil {
+		p.smu.Unlock()
+		return
+	}
+	close(p.resumed)
+	p.resumed = nil
+	p.smu.Unlock()
+
+	p.emit(Resumed, -1)
+}
+
+// Paused reports whether the pool is paused.
+func (p *Pool) Paused() bool {
+	return p.pausedChan() != nil
+}
+
+// pausedChan returns a channel closed once the pool
+// is resumed, nil is returned if it's not paused.
+func (p *Pool) pausedChan() <-chan struct{} {
+	p.smu.Lock()
+	defer p.smu.Unlock()
+	return p.resumed
+}
+
+// Scale changes the workers count, workers are added right away
+// while the retired ones finish their in-flight jobs first.
+func (p *Pool) Scale(n int) error {
+	if n < 1 {
+		return NewErrorFmt("bad workers count: %v", n)
+	}
+
+	p.mu.Lock()
+	changed := p.count != n
+	p.count = n
+	p.mu.Unlock()
+
+	var started []int
+
+	p.smu.Lock()
+	if p.run != nil {
+		active := 0
+		for _, w := range p.states {
+			if !w.retiring {
+				active++
+			}
+		}
+
+		for ; active < n; active++ {
+			started = append(started, p.spawn())
+		}
+
+		// Retire the idle workers, the busy ones
+		// retire themselves once they become idle.
+		for _, id := range p.ids() {
+			if active <= n {
+				break
+			}
+			if w := p.states[id]; !w.retiring && w.Type == "" {
+				w.retire()
+				active--
+			}
+		}
+	}
+	p.smu.Unlock()
+
+	if changed {
+		p.emit(Scaled, -1)
+	}
+	for _, id := range started {
+		p.emit(WorkerStarted, id)
+	}
+	return nil
+}
+
+// poolRun represents the state shared by the workers of a running pool.
+type poolRun struct {
+	ctx  context.Context // Jobs context.
+	wg   *sync.WaitGroup // Running goroutines.
+	in   <-chan Message  // Fan-out channel.
+	next int             // Next worker ID.
+}
+
+// workerState represents a pool worker and the job it runs.
+type workerState struct {
+	Type  string    // Job type, empty if idle.
+	Args  *Args     // Job arguments.
+	Since time.Time // Job start time.
+
+	quit     chan struct{} // Closed to retire the worker.
+	retiring bool
+}
+
+// retire asks the worker to quit.
+func (w *workerState) retire() {
+	w.retiring = true
+	close(w.quit)
+}
+
+// start starts the configured workers count.
+func (p *Pool) start(r *poolRun) {
+	n := p.workers()
+
+	var started []int
+
+	p.smu.Lock()
+	p.run = r
+	for i := 0; i < n; i++ {
+		started = append(started, p.spawn())
+	}
+	p.smu.Unlock()
+
+	for _, id := range started {
+		p.emit(WorkerStarted, id)
+	}
+}
+
+// stop prevents starting new workers, the running
+// ones quit once the fan-out channel is closed.
+func (p *Pool) stop() {
+	p.smu.Lock()
+	defer p.smu.Unlock()
+	p.run = nil
+}
+
+// spawn starts a worker, it must be called holding smu.
+func (p *Pool) spawn() int {
+	r := p.run
+	id := r.next
+	r.next++
+
+	w := &workerState{quit: make(chan struct{})}
+	p.states[id] = w
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		p.worker(r.ctx, id, w, r.in)
+	}()
+
+	return id
+}
+
+// worker executes jobs from the in channel until the
+// channel is closed or the worker is retired.
+func (p *Pool) worker(ctx context.Context, id int, w *workerState, in <-chan Message) {
+	defer func() {
+		p.smu.Lock()
+		delete(p.states, id)
+		p.smu.Unlock()
+
+		p.emit(WorkerRetired, id)
+	}()
+
+	for {
+		var msg Message
+		var ok bool
+
+		select {
+		case <-w.quit:
+			return
+		case msg, ok = <-in:
+			if !ok {
+				return
+			}
+		}
+
+		// Defer the jobs of the saturated types.
+		if delay, ok := p.acquire(msg.Type()); !ok {
+			p.postpone(msg, delay)
+			continue
+		}
+
+		p.busy(w, msg)
+		ok = p.process(ctx, msg)
+		p.done(msg.Type())
+
+		if p.idle(w) || !ok {
+			return
+		}
+	}
+}
+
+// busy records the job run by the worker.
+func (p *Pool) busy(w *workerState, msg Message) {
+	p.smu.Lock()
+	defer p.smu.Unlock()
+	w.Type, w.Args, w.Since = msg.Type(), msg.Args(), time.Now()
+}
+
+// idle marks the worker idle, it returns true if the worker
+// must retire because the pool was scaled down meanwhile.
+func (p *Pool) idle(w *workerState) bool {
+	n := p.workers()
+
+	p.smu.Lock()
+	defer p.smu.Unlock()
+	w.Type, w.Args, w.Since = "", nil, time.Time{}
+
+	active := 0
+	for _, v := range p.states {
+		if !v.retiring {
+			active++
+		}
+	}
+
+	if active > n && !w.retiring {
+		w.retire()
+	}
+	return w.retiring
+}
+
+// ids returns the workers IDs in ascending order,
+// it must be called holding smu.
+func (p *Pool) ids() []int {
+	ids := make([]int, 0, len(p.states))
+	for id := range p.states {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// dump logs the state of the pool and of its workers.
+func (p *Pool) dump() {
+	ready, failed, err := p.queue.Size()
+	if err != nil {
+		p.logger.Error("Size failure", "error", err)
+	}
+
+	p.smu.Lock()
+	defer p.smu.Unlock()
+
+	p.logger.Info("Pool state", "workers", len(p.states), "paused", p.resumed != nil,
+		"ready", ready, "failed", failed)
+
+	for _, id := range p.ids() {
+		if w := p.states[id]; w.Type != "" {
+			// The arguments aren't logged, they may hold secrets.
+			p.logger.Info("Worker busy", "worker", id, "type", w.Type,
+				"running", time.Since(w.Since))
+		} else {
+			p.logger.Info("Worker idle", "worker", id)
+		}
+	}
+}
+
+// PoolStats represents a snapshot of the pool workers.
+type PoolStats struct {
+	Workers int  // Configured workers count.
+	Busy    int  // Workers running a job.
+	Idle    int  // Workers waiting for a job.
+	Paused  bool // Whether the pool is paused.
+}
+
+// Stats returns a snapshot of the pool workers.
+func (p *Pool) Stats() PoolStats {
+	st := PoolStats{Workers: p.workers()}
+
+	p.smu.Lock()
+	defer p.smu.Unlock()
+
+	for _, w := range p.states {
+		if w.Type != "" {
+			st.Busy++
+		} else {
+			st.Idle++
+		}
+	}
+	st.Paused = p.resumed != nil
+
+	return st
+}
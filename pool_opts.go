@@ -1,5 +1,10 @@
 package worker
 
+import (
+	"log/slog"
+	"time"
+)
+
 // SetQueue assigns a custom queue to worker pool.
 func SetQueue(q Queue) func(*Pool) {
 	return func(p *Pool) {
@@ -13,3 +18,81 @@ func SetWorkers(n int) func(*Pool) {
 		p.count = n
 	}
 }
+
+// SetTTR configures the time to run of a job, the job
+// context is cancelled once the duration elapses.
+func SetTTR(d time.Duration) func(*Pool) {
+	return func(p *Pool) {
+		p.ttr = d
+	}
+}
+
+// SetLogger assigns a custom logger to worker pool, the logger
+// is shared with the scheduler, the autoscaler and the middleware
+// having no logger of their own. The default middleware logs
+// with it too (see StructuredStack).
+func SetLogger(l *slog.Logger) func(*Pool) {
+	return func(p *Pool) {
+		p.logger = l
+	}
+}
+
+// SetMiddleware replaces the default middleware stack, CommonStack
+// or StructuredStack if the pool logger is set (see SetLogger).
+func SetMiddleware(handlers ...Handler) func(*Pool) {
+	return func(p *Pool) {
+		p.handlers = append([]Handler{}, handlers...)
+	}
+}
+
+// SetRetryPolicy configures the retry policy of failed jobs.
+func SetRetryPolicy(r RetryPolicy) func(*Pool) {
+	return func(p *Pool) {
+		p.retry = r
+	}
+}
+
+// SetScheduler assigns a custom scheduler to worker pool.
+func SetScheduler(s *Scheduler) func(*Pool) {
+	return func(p *Pool) {
+		p.scheduler = s
+	}
+}
+
+// SetShutdownTimeout configures how long the pool waits for the
+// in-flight jobs on shutdown, the jobs still running afterwards
+// are released back to the queue.
+func SetShutdownTimeout(d time.Duration) func(*Pool) {
+	return func(p *Pool) {
+		p.shutdown = d
+	}
+}
+
+// SetAutoscaler enables autoscaling, the workers count
+// is kept within the autoscaler bounds.
+func SetAutoscaler(a *Autoscaler) func(*Pool) {
+	return func(p *Pool) {
+		p.autoscale = a
+	}
+}
+
+// SetSignalPolicy configures the handled unix signals, use
+// NoSignals to rely on the Run context only.
+func SetSignalPolicy(sp SignalPolicy) func(*Pool) {
+	return func(p *Pool) {
+		p.signals = sp
+	}
+}
+
+// SetReloadable applies the options and registers them to be
+// reapplied on reload, e.g. options reading a configuration file.
+// Reloadable options must not replace the queue, the middleware
+// stack is rebuilt after they run (see SetMiddleware).
+func SetReloadable(opts ...func(*Pool)) func(*Pool) {
+	return func(p *Pool) {
+		for _, opt := range opts {
+			opt(p)
+		}
+		p.reload = append(p.reload, opts...)
+	}
+}
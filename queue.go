@@ -1,26 +1,63 @@
 package worker
 
 import (
+	"time"
+
 	"github.com/bitly/go-simplejson"
 )
 
 type Message interface {
 	Type() string
 	Args() *Args
+	Attempts() int
+	Version() int
+	Meta() *Meta
 }
 
 type Queue interface {
 	Put(Job) error
+	PutIn(Job, time.Duration) error
+	PutAt(Job, time.Time) error
 	Get() (Message, error)
 	Delete(Message) error
-	Reject(Message) error
+	Release(Message, time.Duration) error
+	Reject(Message, error) error
 	Size() (uint64, uint64, error)
 }
 
+// FailedJob represents a rejected job.
+type FailedJob struct {
+	ID       uint64   // Backend specific job ID.
+	Type     string   // Job type.
+	Args     *Args    // Job arguments.
+	Attempts int      // Failed attempts before rejection.
+	Failure  *Failure // Failure details.
+}
+
+// DeadLetter is implemented by queues which allow
+// inspecting and recovering the failed jobs.
+type DeadLetter interface {
+	ListFailed(limit int) ([]*FailedJob, error)
+	Requeue(id uint64) error
+	RequeueAll() (int, error)
+	Purge(id uint64) error
+}
+
+// Deferrer is implemented by queues which allow putting a job
+// back without counting an attempt, e.g. when it's throttled.
+type Deferrer interface {
+	Defer(m Message, delay time.Duration) error
+}
+
 // Payload represents a queue message payload.
 type Payload struct {
-	Type string      `json:"type"`
-	Args interface{} `json:"args"`
+	Type     string      `json:"type"`
+	Args     interface{} `json:"args"`
+	Attempts int         `json:"attempts,omitempty"`
+	Unique   string      `json:"unique,omitempty"`
+	Token    string      `json:"unique_token,omitempty"`
+	Version  int         `json:"version,omitempty"`
+	Meta     *Meta       `json:"meta,omitempty"`
 }
 
 type data struct {
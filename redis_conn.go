@@ -0,0 +1,374 @@
+package worker
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisError represents an error reply.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// errRedisAborted is returned by watch if a watched key was modified.
+var errRedisAborted = redisError("transaction aborted, watched key modified")
+
+// redisConn represents a minimal RESP client, commands
+// are serialized over a single connection.
+type redisConn struct {
+	mu      sync.Mutex
+	addr    string
+	auth    string
+	timeout time.Duration
+
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// newRedisConn returns a connected client.
+func newRedisConn(addr, auth string, timeout time.Duration) (*redisConn, error) {
+	c := &redisConn{addr: addr, auth: auth, timeout: timeout}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// dial opens the connection and authenticates if required.
+func (c *redisConn) dial() error {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	c.w = bufio.NewWriter(conn)
+
+	if c.auth != "" {
+		if _, err := c.roundTrip("AUTH", c.auth); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes the connection, next command reconnects.
+func (c *redisConn) close() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Close closes the connection.
+func (c *redisConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.close()
+}
+
+// do sends a command and returns its reply, error replies
+// are returned as redisError values.
+func (c *redisConn) do(args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := c.roundTrip(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// The connection state is unknown after an I/O error.
+		c.close()
+	}
+	return v, err
+}
+
+// multi sends the commands in a MULTI/EXEC transaction
+// and returns their replies.
+func (c *redisConn) multi(cmds ...[]interface{}) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := c.transaction(cmds)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// The connection state is unknown after an I/O error.
+		c.close()
+	}
+	return v, err
+}
+
+// watch watches the keys and sends the reads, the transaction
+// built from their replies is aborted if a key was modified
+// meanwhile. The transaction is skipped if build returns no
+// commands, its replies are nil.
+func (c *redisConn) watch(keys []interface{}, reads [][]interface{}, build func([]interface{}) ([][]interface{}, error)) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := c.watched(keys, reads, build)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// The connection state is unknown after an I/O error.
+		c.close()
+	}
+	return v, err
+}
+
+func (c *redisConn) watched(keys []interface{}, reads [][]interface{}, build func([]interface{}) ([][]interface{}, error)) ([]interface{}, error) {
+	if _, err := c.roundTrip(append([]interface{}{"WATCH"}, keys...)...); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(reads))
+	for i, args := range reads {
+		v, err := c.roundTrip(args...)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = v
+	}
+
+	cmds, err := build(replies)
+	if err != nil || len(cmds) == 0 {
+		if _, uerr := c.roundTrip("UNWATCH"); uerr != nil {
+			return nil, uerr
+		}
+		return nil, err
+	}
+
+	return c.transaction(cmds)
+}
+
+// transaction pipelines the transaction commands, the queuing
+// errors abort the transaction and are returned by EXEC.
+func (c *redisConn) transaction(cmds [][]interface{}) ([]interface{}, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	if err := c.write("MULTI"); err != nil {
+		return nil, err
+	}
+	for _, args := range cmds {
+		if err := c.write(args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.write("EXEC"); err != nil {
+		return nil, err
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	// Read the MULTI and the QUEUED replies.
+	for range len(cmds) + 1 {
+		if _, err := c.read(); err != nil {
+			if _, ok := err.(redisError); !ok {
+				return nil, err
+			}
+		}
+	}
+
+	v, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+
+	if v == nil {
+		return nil, errRedisAborted
+	}
+
+	replies, ok := v.([]interface{})
+	if !ok {
+		return nil, NewErrorFmt("redis: expecting array, got %v", v)
+	}
+
+	for _, r := range replies {
+		if err, ok := r.(redisError); ok {
+			return replies, err
+		}
+	}
+	return replies, nil
+}
+
+func (c *redisConn) roundTrip(args ...interface{}) (interface{}, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+// write buffers a command.
+func (c *redisConn) write(args ...interface{}) error {
+	c.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var b []byte
+		switch v := arg.(type) {
+		case []byte:
+			b = v
+		case string:
+			b = []byte(v)
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		case uint32:
+			b = strconv.AppendUint(nil, uint64(v), 10)
+		case uint64:
+			b = strconv.AppendUint(nil, v, 10)
+		default:
+			return NewErrorFmt("redis: bad argument %v", arg)
+		}
+
+		c.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+		c.w.Write(b)
+		c.w.WriteString("\r\n")
+	}
+	return nil
+}
+
+// read parses a single reply.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, NewErrorFmt("redis: bad reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		// The error items (e.g. EXEC replies) are kept
+		// as values, the remaining items are read.
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+				items[i] = err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, NewErrorFmt("redis: bad reply %q", line)
+}
+
+// doInt sends a command and returns its integer reply.
+func (c *redisConn) doInt(args ...interface{}) (int64, error) {
+	v, err := c.do(args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := v.(int64)
+	if !ok {
+		return 0, NewErrorFmt("redis: expecting integer, got %v", v)
+	}
+	return n, nil
+}
+
+// doBytes sends a command and returns its bulk reply,
+// nil is returned for the nil reply.
+func (c *redisConn) doBytes(args ...interface{}) ([]byte, error) {
+	v, err := c.do(args...)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, NewErrorFmt("redis: expecting bulk, got %v", v)
+	}
+	return b, nil
+}
+
+// doStrings sends a command and returns its array reply.
+func (c *redisConn) doStrings(args ...interface{}) ([]string, error) {
+	v, err := c.do(args...)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, NewErrorFmt("redis: expecting array, got %v", v)
+	}
+
+	list := make([]string, len(items))
+	for i, item := range items {
+		b, ok := item.([]byte)
+		if !ok {
+			return nil, NewErrorFmt("redis: expecting bulk, got %v", item)
+		}
+		list[i] = string(b)
+	}
+	return list, nil
+}
@@ -0,0 +1,693 @@
+package worker
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	RedisHost = "localhost" // Redis default host.
+	RedisPort = "6379"      // Redis default port.
+	RedisName = "default"   // Redis default queue.
+	RedisPrio = 100         // Redis default job priority.
+)
+
+var (
+	RedisTimeout      time.Duration = 1 * time.Second        // Redis reserve timeout.
+	RedisPollInterval time.Duration = 100 * time.Millisecond // Redis ready lists polling interval.
+	RedisIOTimeout    time.Duration = 5 * time.Second        // Redis connection I/O timeout.
+	RedisTTR          time.Duration = 2 * DefaultTTR         // Redis default TTR (time to run).
+)
+
+// redisMessage represents data returned by Get.
+type redisMessage struct {
+	id        uint64 // Message ID.
+	*Envelope        // Holds parsed json.
+}
+
+// newRedisMessage returns an instance of redisMessage.
+func newRedisMessage(c Codec, id uint64, payload []byte) (*redisMessage, error) {
+	base, err := decodeEnvelope(c, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &redisMessage{
+		id:       id,
+		Envelope: base,
+	}
+
+	return env, nil
+}
+
+// ID returns the backend ID of the message.
+func (m *redisMessage) ID() string { return strconv.FormatUint(m.id, 10) }
+
+// RedisQueue represents a reliable queue stored in Redis.
+//
+// Jobs are stored in hashes while their IDs move between lists:
+// each priority has a ready list, reserved jobs are moved atomically
+// to a processing list owned by the consumer, delayed jobs wait in a
+// sorted set and rejected jobs are appended to the failed list.
+// Consumers refresh a heartbeat in the background until the queue is
+// closed, the processing lists of the consumers silent for more than
+// TTR are considered abandoned and their jobs are returned to the
+// ready lists. The multi-step updates of a job run in transactions.
+type RedisQueue struct {
+	Host     string        // Redis host.
+	Port     string        // Redis port.
+	Password string        // Redis password.
+	Name     string        // Redis queue name, used as keys prefix.
+	Consumer string        // Redis consumer name, must be unique per process.
+	Prio     uint32        // Redis priority.
+	TTR      time.Duration // Redis time to run.
+	Locker   Locker        // Unique jobs lock store, defaults to Redis.
+	Codec    Codec         // Payloads codec.
+
+	conn *redisConn
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	checked time.Time // Last abandoned jobs check.
+}
+
+// NewRedisQueue returns a queue instance using custom options.
+func NewRedisQueue(opts ...func(*RedisQueue)) (Queue, error) {
+	q := &RedisQueue{
+		Host:     RedisHost,
+		Port:     RedisPort,
+		Name:     RedisName,
+		Consumer: hostname + ":" + strconv.Itoa(os.Getpid()) + ":" + strconv.FormatUint(rand.Uint64(), 36),
+		Prio:     RedisPrio,
+		TTR:      RedisTTR,
+		Codec:    JSONCodec,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	addr := net.JoinHostPort(q.Host, q.Port)
+
+	conn, err := newRedisConn(addr, q.Password, RedisIOTimeout)
+	if err != nil {
+		return nil, err
+	}
+	q.conn = conn
+
+	if q.Locker == nil {
+		q.Locker = &RedisLocker{Prefix: q.key("lock"), conn: conn}
+	}
+
+	q.done = make(chan struct{})
+	q.wg.Add(1)
+	go q.beat()
+
+	return q, nil
+}
+
+// Close stops the heartbeat, unregisters the consumer and closes the
+// connection to Redis. The consumer still holding reserved jobs stays
+// registered, its jobs are recovered once the heartbeat expires.
+func (q *RedisQueue) Close() error {
+	var err error
+	q.once.Do(func() {
+		close(q.done)
+		q.wg.Wait()
+		err = q.unregister()
+	})
+
+	if cerr := q.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// unregister removes the consumer unless it holds reserved jobs.
+func (q *RedisQueue) unregister() error {
+	n, err := q.conn.doInt("LLEN", q.processingKey(q.Consumer))
+	if err != nil || n > 0 {
+		return err
+	}
+
+	_, err = q.conn.do("ZREM", q.key("consumers"), q.Consumer)
+	return err
+}
+
+// key returns a key prefixed with the queue name.
+func (q *RedisQueue) key(parts ...string) string {
+	k := "worker:" + q.Name
+	for _, p := range parts {
+		k += ":" + p
+	}
+	return k
+}
+
+// jobKey returns the key of the job hash.
+func (q *RedisQueue) jobKey(id uint64) string {
+	return q.key("job", strconv.FormatUint(id, 10))
+}
+
+// processingKey returns the processing list key of a consumer.
+func (q *RedisQueue) processingKey(consumer string) string {
+	return q.key("processing", consumer)
+}
+
+// Put puts the job in the queue.
+func (q *RedisQueue) Put(j Job) error {
+	return q.PutIn(j, 0)
+}
+
+// PutIn puts the job in the queue, the job becomes
+// ready after the delay elapses.
+func (q *RedisQueue) PutIn(j Job, delay time.Duration) error {
+	prio := q.Prio
+
+	if v, ok := unwrapJob(j).(Priority); ok {
+		prio = v.Prio()
+	}
+
+	return putUnique(q.Locker, q.Codec, j, func(body []byte) error {
+		n, err := q.conn.doInt("INCR", q.key("seq"))
+		if err != nil {
+			return err
+		}
+		id := uint64(n)
+
+		if _, err := q.conn.do("HSET", q.jobKey(id), "body", body, "prio", prio); err != nil {
+			return err
+		}
+
+		return q.schedule(id, prio, delay)
+	})
+}
+
+// PutAt puts the job in the queue, the job
+// becomes ready at the specified time.
+func (q *RedisQueue) PutAt(j Job, t time.Time) error {
+	return q.PutIn(j, time.Until(t))
+}
+
+// Get reserves a job from the queue, the job is moved to
+// the consumer's processing list until it's acknowledged.
+func (q *RedisQueue) Get() (Message, error) {
+	deadline := time.Now().Add(RedisTimeout)
+
+	for {
+		if err := q.maintain(); err != nil {
+			return nil, err
+		}
+
+		msg, err := q.reserve()
+		if err != nil || msg != nil {
+			return msg, err
+		}
+
+		if time.Now().Add(RedisPollInterval).After(deadline) {
+			return nil, &Error{Err: "timeout", IsTimeout: true}
+		}
+		time.Sleep(RedisPollInterval)
+	}
+}
+
+// Delete deletes a job from the queue.
+func (q *RedisQueue) Delete(m Message) error {
+	env, ok := m.(*redisMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	if _, err := q.conn.multi(
+		q.heartbeatCmd(),
+		q.ackCmd(env.id),
+		[]interface{}{"DEL", q.jobKey(env.id)},
+	); err != nil {
+		return err
+	}
+
+	return unlockUnique(q.Locker, env.Envelope)
+}
+
+// Release puts the job back in the queue incrementing its attempts
+// counter, the job becomes ready after the delay elapses.
+func (q *RedisQueue) Release(m Message, delay time.Duration) error {
+	env, ok := m.(*redisMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	env.incAttempts()
+	return q.Defer(env, delay)
+}
+
+// Defer puts the job back in the queue without counting
+// an attempt, the job becomes ready after the delay elapses.
+func (q *RedisQueue) Defer(m Message, delay time.Duration) error {
+	env, ok := m.(*redisMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	update, err := q.updateCmd(env)
+	if err != nil {
+		return err
+	}
+
+	prio, err := q.prio(env.id)
+	if err != nil {
+		return err
+	}
+
+	cmds := [][]interface{}{q.heartbeatCmd(), update}
+	cmds = append(cmds, q.scheduleCmds(env.id, prio, delay)...)
+	cmds = append(cmds, q.ackCmd(env.id))
+
+	_, err = q.conn.multi(cmds...)
+	return err
+}
+
+// Reject rejects the job moving it to the failed list.
+func (q *RedisQueue) Reject(m Message, reason error) error {
+	env, ok := m.(*redisMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	env.reject(reason)
+	update, err := q.updateCmd(env)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.conn.multi(
+		q.heartbeatCmd(),
+		update,
+		[]interface{}{"LPUSH", q.key("failed"), env.id},
+		q.ackCmd(env.id),
+	); err != nil {
+		return err
+	}
+
+	return unlockUnique(q.Locker, env.Envelope)
+}
+
+// Size returns the number of ready and failed jobs.
+func (q *RedisQueue) Size() (uint64, uint64, error) {
+	prios, err := q.conn.doStrings("ZRANGE", q.key("prios"), 0, -1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var ready uint64
+	for _, p := range prios {
+		n, err := q.conn.doInt("LLEN", q.key("ready", p))
+		if err != nil {
+			return 0, 0, err
+		}
+		ready += uint64(n)
+	}
+
+	failed, err := q.conn.doInt("LLEN", q.key("failed"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ready, uint64(failed), nil
+}
+
+// ListFailed returns up to limit failed jobs, all the
+// failed jobs are returned if limit is not positive.
+func (q *RedisQueue) ListFailed(limit int) ([]*FailedJob, error) {
+	if limit <= 0 {
+		limit = 0
+	}
+
+	ids, err := q.conn.doStrings("LRANGE", q.key("failed"), 0, limit-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*FailedJob
+	for _, v := range ids {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := q.conn.doBytes("HGET", q.jobKey(id), "body")
+		if err != nil {
+			return nil, err
+		}
+
+		env, err := decodeEnvelope(q.Codec, body)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, env.failed(id))
+	}
+
+	return jobs, nil
+}
+
+// Requeue moves the failed job back to its ready list.
+func (q *RedisQueue) Requeue(id uint64) error {
+	job := q.jobKey(id)
+	reads := [][]interface{}{
+		{"LPOS", q.key("failed"), id},
+		{"HGET", job, "body"},
+		{"HGET", job, "prio"},
+	}
+
+	replies, err := q.conn.watch([]interface{}{q.key("failed"), job}, reads, func(v []interface{}) ([][]interface{}, error) {
+		body, _ := v[1].([]byte)
+		if v[0] == nil || body == nil {
+			return nil, NewErrorFmt("job %v not found", id)
+		}
+
+		env, err := newRedisMessage(q.Codec, id, body)
+		if err != nil {
+			return nil, err
+		}
+
+		prio, err := q.parsePrio(v[2])
+		if err != nil {
+			return nil, err
+		}
+
+		env.reset()
+		update, err := q.updateCmd(env)
+		if err != nil {
+			return nil, err
+		}
+
+		cmds := [][]interface{}{{"LREM", q.key("failed"), 1, id}, update}
+		return append(cmds, q.scheduleCmds(id, prio, 0)...), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if n, _ := replies[0].(int64); n == 0 {
+		return NewErrorFmt("job %v not found", id)
+	}
+	return nil
+}
+
+// RequeueAll moves all the failed jobs back to their ready lists.
+func (q *RedisQueue) RequeueAll() (int, error) {
+	jobs, err := q.ListFailed(0)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, job := range jobs {
+		if err := q.Requeue(job.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(jobs), nil
+}
+
+// Purge deletes the failed job.
+func (q *RedisQueue) Purge(id uint64) error {
+	n, err := q.conn.doInt("LREM", q.key("failed"), 1, id)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return NewErrorFmt("job %v not found", id)
+	}
+
+	_, err = q.conn.do("DEL", q.jobKey(id))
+	return err
+}
+
+// reserve moves the first job of the most urgent non empty ready
+// list to the processing list, nil is returned if there is none.
+func (q *RedisQueue) reserve() (Message, error) {
+	prios, err := q.conn.doStrings("ZRANGE", q.key("prios"), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range prios {
+		v, err := q.conn.doBytes("RPOPLPUSH", q.key("ready", p), q.processingKey(q.Consumer))
+		if err != nil {
+			return nil, err
+		}
+
+		if v == nil {
+			continue
+		}
+
+		id, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := q.conn.doBytes("HGET", q.jobKey(id), "body")
+		if err != nil {
+			return nil, err
+		}
+
+		return newRedisMessage(q.Codec, id, body)
+	}
+
+	return nil, nil
+}
+
+// schedule pushes the job to its ready list or
+// to the delayed set if the delay is positive.
+func (q *RedisQueue) schedule(id uint64, prio uint32, delay time.Duration) error {
+	_, err := q.conn.multi(q.scheduleCmds(id, prio, delay)...)
+	return err
+}
+
+// scheduleCmds returns the commands scheduling the job.
+func (q *RedisQueue) scheduleCmds(id uint64, prio uint32, delay time.Duration) [][]interface{} {
+	if delay > 0 {
+		at := time.Now().Add(delay).UnixMilli()
+		return [][]interface{}{{"ZADD", q.key("delayed"), at, id}}
+	}
+
+	p := strconv.FormatUint(uint64(prio), 10)
+	return [][]interface{}{
+		{"ZADD", q.key("prios"), p, p},
+		{"LPUSH", q.key("ready", p), id},
+	}
+}
+
+// readyKey registers the priority and returns its ready list key.
+func (q *RedisQueue) readyKey(prio uint32) (string, error) {
+	p := strconv.FormatUint(uint64(prio), 10)
+	if _, err := q.conn.do("ZADD", q.key("prios"), p, p); err != nil {
+		return "", err
+	}
+
+	return q.key("ready", p), nil
+}
+
+// ackCmd returns the command removing the job from the processing list.
+func (q *RedisQueue) ackCmd(id uint64) []interface{} {
+	return []interface{}{"LREM", q.processingKey(q.Consumer), 1, id}
+}
+
+// update stores the message body.
+func (q *RedisQueue) update(env *redisMessage) error {
+	cmd, err := q.updateCmd(env)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.conn.do(cmd...)
+	return err
+}
+
+// updateCmd returns the command storing the message body.
+func (q *RedisQueue) updateCmd(env *redisMessage) ([]interface{}, error) {
+	body, err := encodeEnvelope(q.Codec, env.Envelope)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{"HSET", q.jobKey(env.id), "body", body}, nil
+}
+
+// prio returns the job priority.
+func (q *RedisQueue) prio(id uint64) (uint32, error) {
+	v, err := q.conn.do("HGET", q.jobKey(id), "prio")
+	if err != nil {
+		return 0, err
+	}
+	return q.parsePrio(v)
+}
+
+// parsePrio parses the priority field of the job hash.
+func (q *RedisQueue) parsePrio(v interface{}) (uint32, error) {
+	b, _ := v.([]byte)
+	if b == nil {
+		return q.Prio, nil
+	}
+
+	n, err := strconv.ParseUint(string(b), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(n), nil
+}
+
+// heartbeat marks the consumer as alive.
+func (q *RedisQueue) heartbeat() error {
+	_, err := q.conn.do(q.heartbeatCmd()...)
+	return err
+}
+
+// heartbeatCmd returns the command marking the consumer as alive.
+func (q *RedisQueue) heartbeatCmd() []interface{} {
+	now := time.Now().UnixMilli()
+	return []interface{}{"ZADD", q.key("consumers"), now, q.Consumer}
+}
+
+// beat refreshes the heartbeat until the queue is closed, the
+// consumer stays alive while its jobs run longer than TTR.
+func (q *RedisQueue) beat() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(max(q.TTR/3, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			// Failures are retried on the next tick.
+			q.heartbeat()
+		}
+	}
+}
+
+// maintain refreshes the heartbeat, promotes the due delayed
+// jobs and recovers the abandoned jobs (at most once a second).
+func (q *RedisQueue) maintain() error {
+	if err := q.heartbeat(); err != nil {
+		return err
+	}
+
+	if err := q.promote(); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	check := time.Since(q.checked) >= time.Second
+	if check {
+		q.checked = time.Now()
+	}
+	q.mu.Unlock()
+
+	if !check {
+		return nil
+	}
+
+	return q.recover()
+}
+
+// promote moves the due delayed jobs to their ready lists.
+func (q *RedisQueue) promote() error {
+	now := time.Now().UnixMilli()
+	ids, err := q.conn.doStrings("ZRANGEBYSCORE", q.key("delayed"), "-inf", now, "LIMIT", 0, 100)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range ids {
+		// Only the consumer which removes the job promotes it.
+		n, err := q.conn.doInt("ZREM", q.key("delayed"), v)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		prio, err := q.prio(id)
+		if err != nil {
+			return err
+		}
+
+		if err := q.schedule(id, prio, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recover returns the jobs of the consumers silent
+// for more than TTR back to their ready lists.
+func (q *RedisQueue) recover() error {
+	expired := time.Now().Add(-q.TTR).UnixMilli()
+	consumers, err := q.conn.doStrings("ZRANGEBYSCORE", q.key("consumers"), "-inf", expired)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range consumers {
+		// Only the consumer which removes the dead one recovers its jobs.
+		n, err := q.conn.doInt("ZREM", q.key("consumers"), c)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		// Move the jobs one by one, the move itself is atomic.
+		for {
+			v, err := q.conn.doBytes("LINDEX", q.processingKey(c), -1)
+			if err != nil {
+				return err
+			}
+
+			if v == nil {
+				break
+			}
+
+			id, err := strconv.ParseUint(string(v), 10, 64)
+			if err != nil {
+				return err
+			}
+
+			prio, err := q.prio(id)
+			if err != nil {
+				return err
+			}
+
+			ready, err := q.readyKey(prio)
+			if err != nil {
+				return err
+			}
+
+			if _, err := q.conn.do("RPOPLPUSH", q.processingKey(c), ready); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,288 @@
+package worker_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+// prioJob represents a test job with a custom priority.
+type prioJob struct {
+	X int
+	P uint32
+}
+
+func (j *prioJob) Make(args *worker.Args) (worker.Job, error) { return &prioJob{}, nil }
+
+func (j *prioJob) Run() error { return nil }
+
+func (j *prioJob) Prio() uint32 { return j.P }
+
+// newRedisQueue returns a queue connected to the stand-in server.
+func newRedisQueue(t *testing.T, s *redisServer, opts ...func(*worker.RedisQueue)) worker.Queue {
+	host, port := s.addr()
+	opts = append([]func(*worker.RedisQueue){func(q *worker.RedisQueue) {
+		q.Host, q.Port = host, port
+	}}, opts...)
+
+	q, err := worker.NewRedisQueue(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.(*worker.RedisQueue).Close() })
+
+	return q
+}
+
+func TestRedisQueue(t *testing.T) {
+	q := newRedisQueue(t, newRedisServer(t))
+
+	if err := q.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	size, _, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if size != 1 {
+		t.Errorf("expecting size to be %v, got %v", 1, size)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typ := "addJob"
+	if msg.Type() != typ {
+		t.Errorf("expecting %q, got %q", typ, msg.Type())
+	}
+
+	x := msg.Args().Get("X").MustInt(-1)
+	y := msg.Args().Get("Y").MustInt(-1)
+	if x != 1 || y != 2 {
+		t.Errorf("expecting (1, 2), got (%v, %v)", x, y)
+	}
+
+	if err := q.Delete(msg); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := q.Get(); err == nil {
+		t.Error("expecting empty queue")
+	}
+}
+
+func TestRedisQueuePriority(t *testing.T) {
+	q := newRedisQueue(t, newRedisServer(t))
+
+	for i, p := range []uint32{200, 10, 100} {
+		if err := q.Put(&prioJob{X: i, P: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := q.PutIn(&prioJob{X: 3, P: 1}, 300*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []int{1, 2, 0} {
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if x := msg.Args().Get("X").MustInt(-1); x != want {
+			t.Errorf("expecting X to be %v, got %v", want, x)
+		}
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x := msg.Args().Get("X").MustInt(-1); x != 3 {
+		t.Errorf("expecting delayed job, got %v", x)
+	}
+}
+
+func TestRedisQueueRecovery(t *testing.T) {
+	s := newRedisServer(t)
+	ttr := func(q *worker.RedisQueue) { q.TTR = 100 * time.Millisecond }
+
+	crashed := newRedisQueue(t, s, ttr)
+	if err := crashed.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := crashed.Get(); err != nil {
+		t.Fatal(err)
+	}
+	crashed.(*worker.RedisQueue).Close()
+
+	// The job is reserved by the crashed consumer,
+	// it becomes visible once its TTR elapses.
+	q := newRedisQueue(t, s, ttr)
+	time.Sleep(150 * time.Millisecond)
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x := msg.Args().Get("X").MustInt(-1); x != 1 {
+		t.Errorf("expecting X to be %v, got %v", 1, x)
+	}
+}
+
+func TestRedisQueueHeartbeat(t *testing.T) {
+	s := newRedisServer(t)
+	ttr := func(q *worker.RedisQueue) { q.TTR = 100 * time.Millisecond }
+
+	busy := newRedisQueue(t, s, ttr)
+	if err := busy.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := busy.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The job runs longer than TTR, the consumer is alive
+	// so the job isn't recovered by the other consumers.
+	q := newRedisQueue(t, s, ttr)
+	time.Sleep(300 * time.Millisecond)
+
+	if _, err := q.Get(); err == nil {
+		t.Fatal("expecting timeout, got a job")
+	}
+
+	if err := busy.Delete(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisQueueClose(t *testing.T) {
+	s := newRedisServer(t)
+	consumer := func(name string) func(*worker.RedisQueue) {
+		return func(q *worker.RedisQueue) { q.Consumer = name }
+	}
+
+	idle := newRedisQueue(t, s, consumer("idle"))
+	busy := newRedisQueue(t, s, consumer("busy"))
+
+	if err := busy.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := busy.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []worker.Queue{idle, busy} {
+		if err := q.(*worker.RedisQueue).Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The consumer holding a job stays registered to be recovered.
+	s.mu.Lock()
+	consumers := s.zsets["worker:"+worker.RedisName+":consumers"]
+	_, idleOK := consumers["idle"]
+	_, busyOK := consumers["busy"]
+	s.mu.Unlock()
+
+	if idleOK || !busyOK {
+		t.Errorf("expecting only the busy consumer, got idle %v, busy %v", idleOK, busyOK)
+	}
+}
+
+func TestRedisQueueDeadLetter(t *testing.T) {
+	q := newRedisQueue(t, newRedisServer(t))
+	dl := q.(worker.DeadLetter)
+
+	if err := q.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Release(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg, err = q.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Reject(msg, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := dl.ListFailed(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 1 {
+		t.Fatalf("expecting %v jobs, got %v", 1, len(jobs))
+	}
+
+	if job := jobs[0]; job.Attempts != 1 || job.Failure.Err != "boom" {
+		t.Errorf("expecting 1 attempt failed with boom, got %v failed with %v", job.Attempts, job.Failure)
+	}
+
+	if err := dl.Requeue(jobs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The requeued job isn't failed anymore.
+	if err := dl.Requeue(jobs[0].ID); err == nil {
+		t.Error("expecting job not found error")
+	}
+
+	ready, failed, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ready != 1 || failed != 0 {
+		t.Errorf("expecting (1, 0), got (%v, %v)", ready, failed)
+	}
+}
+
+func TestRedisQueueUnique(t *testing.T) {
+	s := newRedisServer(t)
+	q1 := newRedisQueue(t, s)
+	q2 := newRedisQueue(t, s)
+
+	if err := q1.Put(&mailJob{To: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q2.Put(&mailJob{To: "a@example.com"}); err != worker.ErrDuplicate {
+		t.Errorf("expecting %v, got %v", worker.ErrDuplicate, err)
+	}
+
+	msg, err := q2.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q2.Reject(msg, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q1.Put(&mailJob{To: "a@example.com"}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,431 @@
+package worker_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// redisServer represents an in-process stand-in for Redis, it
+// implements the subset of the RESP commands used by the package.
+type redisServer struct {
+	mu      sync.Mutex
+	ln      net.Listener
+	strings map[string]string
+	expires map[string]time.Time
+	hashes  map[string]map[string]string
+	lists   map[string][]string
+	zsets   map[string]map[string]float64
+}
+
+// newRedisServer starts a server listening on a random local port.
+func newRedisServer(t *testing.T) *redisServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &redisServer{
+		ln:      ln,
+		strings: map[string]string{},
+		expires: map[string]time.Time{},
+		hashes:  map[string]map[string]string{},
+		lists:   map[string][]string{},
+		zsets:   map[string]map[string]float64{},
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+// addr returns the host and the port the server listens on.
+func (s *redisServer) addr() (string, string) {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	return host, port
+}
+
+func (s *redisServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	// The commands queued by MULTI, nil outside of a transaction.
+	var queued [][]string
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		var reply interface{}
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "MULTI":
+			queued, reply = [][]string{}, redisStatus("OK")
+		case cmd == "EXEC" && queued != nil:
+			replies := make([]interface{}, len(queued))
+			s.mu.Lock()
+			for i, args := range queued {
+				replies[i] = s.exec(args)
+			}
+			s.mu.Unlock()
+			queued, reply = nil, replies
+		case queued != nil:
+			queued, reply = append(queued, args), redisStatus("QUEUED")
+		default:
+			s.mu.Lock()
+			reply = s.exec(args)
+			s.mu.Unlock()
+		}
+
+		writeReply(w, reply)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand parses a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+
+	return args, nil
+}
+
+type (
+	redisStatus string
+	redisErr    string
+)
+
+func writeReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case redisStatus:
+		w.WriteString("+" + string(v) + "\r\n")
+	case redisErr:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []string:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
+
+func (s *redisServer) exec(args []string) interface{} {
+	cmd, args := strings.ToUpper(args[0]), args[1:]
+	s.expire()
+
+	switch cmd {
+	case "PING":
+		return redisStatus("PONG")
+	case "WATCH", "UNWATCH":
+		// The watched keys aren't tracked, the
+		// transactions are never aborted.
+		return redisStatus("OK")
+	case "AUTH":
+		return redisStatus("OK")
+	case "GET":
+		if v, ok := s.strings[args[0]]; ok {
+			return v
+		}
+		return nil
+	case "SET":
+		if len(args) > 2 && strings.EqualFold(args[2], "NX") {
+			if _, ok := s.strings[args[0]]; ok {
+				return nil
+			}
+		}
+		s.strings[args[0]] = args[1]
+		delete(s.expires, args[0])
+		for i := 2; i < len(args)-1; i++ {
+			if strings.EqualFold(args[i], "PX") {
+				ms, _ := strconv.Atoi(args[i+1])
+				s.expires[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+		}
+		return redisStatus("OK")
+	case "EVAL":
+		// Only the compare-and-delete script of the lockers is supported.
+		if !strings.Contains(args[0], `redis.call("GET", KEYS[1]) == ARGV[1]`) || len(args) < 4 {
+			return redisErr("ERR unsupported script")
+		}
+		if v, ok := s.strings[args[2]]; !ok || v != args[3] {
+			return 0
+		}
+		delete(s.strings, args[2])
+		delete(s.expires, args[2])
+		return 1
+	case "INCR":
+		n, _ := strconv.Atoi(s.strings[args[0]])
+		n++
+		s.strings[args[0]] = strconv.Itoa(n)
+		return n
+	case "DEL":
+		n := 0
+		for _, k := range args {
+			if s.exists(k) {
+				n++
+			}
+			delete(s.strings, k)
+			delete(s.expires, k)
+			delete(s.hashes, k)
+			delete(s.lists, k)
+			delete(s.zsets, k)
+		}
+		return n
+	case "HSET":
+		h, ok := s.hashes[args[0]]
+		if !ok {
+			h = map[string]string{}
+			s.hashes[args[0]] = h
+		}
+		n := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return n
+	case "HGET":
+		if v, ok := s.hashes[args[0]][args[1]]; ok {
+			return v
+		}
+		return nil
+	case "LPUSH":
+		for _, v := range args[1:] {
+			s.lists[args[0]] = append([]string{v}, s.lists[args[0]]...)
+		}
+		return len(s.lists[args[0]])
+	case "RPOP":
+		return s.rpop(args[0])
+	case "RPOPLPUSH":
+		v := s.rpop(args[0])
+		if v != nil {
+			s.lists[args[1]] = append([]string{v.(string)}, s.lists[args[1]]...)
+		}
+		return v
+	case "LLEN":
+		return len(s.lists[args[0]])
+	case "LINDEX":
+		l := s.lists[args[0]]
+		i, _ := strconv.Atoi(args[1])
+		if i < 0 {
+			i += len(l)
+		}
+		if i < 0 || i >= len(l) {
+			return nil
+		}
+		return l[i]
+	case "LPOS":
+		for i, v := range s.lists[args[0]] {
+			if v == args[1] {
+				return i
+			}
+		}
+		return nil
+	case "LRANGE":
+		l := s.lists[args[0]]
+		start, stop := s.bounds(args[1], args[2], len(l))
+		return append([]string{}, l[start:stop]...)
+	case "LREM":
+		count, _ := strconv.Atoi(args[1])
+		var l []string
+		n := 0
+		for _, v := range s.lists[args[0]] {
+			if v == args[2] && (count == 0 || n < count) {
+				n++
+				continue
+			}
+			l = append(l, v)
+		}
+		s.lists[args[0]] = l
+		return n
+	case "ZADD":
+		z, ok := s.zsets[args[0]]
+		if !ok {
+			z = map[string]float64{}
+			s.zsets[args[0]] = z
+		}
+		n := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return redisErr("ERR value is not a valid float")
+			}
+			if _, ok := z[args[i+1]]; !ok {
+				n++
+			}
+			z[args[i+1]] = score
+		}
+		return n
+	case "ZREM":
+		n := 0
+		for _, m := range args[1:] {
+			if _, ok := s.zsets[args[0]][m]; ok {
+				delete(s.zsets[args[0]], m)
+				n++
+			}
+		}
+		return n
+	case "ZRANGE":
+		members := s.sorted(args[0])
+		start, stop := s.bounds(args[1], args[2], len(members))
+		return members[start:stop]
+	case "ZRANGEBYSCORE":
+		min, max := s.score(args[1]), s.score(args[2])
+		var members []string
+		for _, m := range s.sorted(args[0]) {
+			if v := s.zsets[args[0]][m]; v >= min && v <= max {
+				members = append(members, m)
+			}
+		}
+		if len(args) == 6 && strings.EqualFold(args[3], "LIMIT") {
+			offset, _ := strconv.Atoi(args[4])
+			count, _ := strconv.Atoi(args[5])
+			if offset > len(members) {
+				offset = len(members)
+			}
+			members = members[offset:]
+			if count >= 0 && count < len(members) {
+				members = members[:count]
+			}
+		}
+		return append([]string{}, members...)
+	}
+
+	return redisErr("ERR unknown command '" + cmd + "'")
+}
+
+// expire drops the expired keys.
+func (s *redisServer) expire() {
+	now := time.Now()
+	for k, t := range s.expires {
+		if !t.After(now) {
+			delete(s.strings, k)
+			delete(s.expires, k)
+		}
+	}
+}
+
+func (s *redisServer) exists(k string) bool {
+	_, str := s.strings[k]
+	_, h := s.hashes[k]
+	_, l := s.lists[k]
+	_, z := s.zsets[k]
+	return str || h || l || z
+}
+
+func (s *redisServer) rpop(k string) interface{} {
+	l := s.lists[k]
+	if len(l) == 0 {
+		return nil
+	}
+	v := l[len(l)-1]
+	s.lists[k] = l[:len(l)-1]
+	return v
+}
+
+// sorted returns the members of a sorted set ordered by score.
+func (s *redisServer) sorted(k string) []string {
+	z := s.zsets[k]
+	members := make([]string, 0, len(z))
+	for m := range z {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if z[members[i]] != z[members[j]] {
+			return z[members[i]] < z[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
+
+// bounds converts inclusive, possibly negative, indexes to slice bounds.
+func (s *redisServer) bounds(a, b string, n int) (int, int) {
+	start, _ := strconv.Atoi(a)
+	stop, _ := strconv.Atoi(b)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+func (s *redisServer) score(v string) float64 {
+	switch v {
+	case "-inf":
+		return -1e308
+	case "+inf", "inf":
+		return 1e308
+	}
+	f, _ := strconv.ParseFloat(v, 64)
+	return f
+}
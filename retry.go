@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+var (
+	DefaultRetryMin time.Duration = 1 * time.Second  // Default delay of the first retry.
+	DefaultRetryMax time.Duration = 10 * time.Minute // Default retry delay cap.
+)
+
+// RetryPolicy decides whether a failed job is released
+// back to the queue or rejected.
+type RetryPolicy interface {
+	// Backoff receives the number of the failed attempts so far,
+	// it returns the delay before the next attempt and false
+	// when the job should be rejected.
+	Backoff(attempt int) (time.Duration, bool)
+}
+
+// Retryable is implemented by jobs which want to override
+// the pool retry policy.
+type Retryable interface {
+	RetryPolicy() RetryPolicy
+}
+
+// noRetry rejects jobs on first failure.
+type noRetry struct{}
+
+func (noRetry) Backoff(int) (time.Duration, bool) { return 0, false }
+
+// NoRetry is a retry policy which never retries failed jobs.
+var NoRetry RetryPolicy = noRetry{}
+
+// ExponentialBackoff retries jobs with an exponentially growing delay.
+type ExponentialBackoff struct {
+	MaxAttempts int           // Max attempts, including the first run.
+	Min         time.Duration // Delay of the first retry.
+	Max         time.Duration // Delay cap.
+	Factor      float64       // Growth factor.
+	Jitter      float64       // Randomization factor in [0, 1].
+}
+
+// NewExponentialBackoff returns a policy using default settings.
+func NewExponentialBackoff(attempts int) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		MaxAttempts: attempts,
+		Min:         DefaultRetryMin,
+		Max:         DefaultRetryMax,
+		Factor:      2,
+		Jitter:      0.2,
+	}
+}
+
+func (b *ExponentialBackoff) Backoff(attempt int) (time.Duration, bool) {
+	if attempt >= b.MaxAttempts {
+		return 0, false
+	}
+
+	d := float64(b.Min) * math.Pow(b.Factor, float64(attempt-1))
+	if max := float64(b.Max); b.Max > 0 && d > max {
+		d = max
+	}
+
+	// Spread the retries to avoid thundering herds.
+	if b.Jitter > 0 {
+		d -= d * b.Jitter * rand.Float64()
+	}
+
+	return time.Duration(d), true
+}
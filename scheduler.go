@@ -0,0 +1,184 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultScheduleLockTTL time.Duration = 1 * time.Minute // Default schedule lock duration.
+)
+
+// Entry represents a recurring job registered in a Scheduler.
+type Entry struct {
+	Spec     string    // Cron expression.
+	Schedule Schedule  // Parsed cron expression.
+	Job      Job       // Job to enqueue.
+	Prev     time.Time // Last activation time.
+	Next     time.Time // Next activation time.
+
+	key string // Activation lock key prefix.
+}
+
+// Scheduler enqueues recurring jobs using cron expressions.
+type Scheduler struct {
+	Locker  Locker        // Guards against double enqueueing.
+	LockTTL time.Duration // Lock duration of an activation.
+	Logger  *slog.Logger  // Failures logger, the pool logger if nil.
+
+	mu      sync.Mutex
+	entries []*Entry
+	changed chan struct{}
+}
+
+// NewScheduler returns a scheduler instance using custom options.
+func NewScheduler(opts ...func(*Scheduler)) *Scheduler {
+	s := &Scheduler{
+		Locker:  NewMemoryLocker(),
+		LockTTL: DefaultScheduleLockTTL,
+		changed: make(chan struct{}, 1),
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Add registers the job to be enqueued on schedule.
+func (s *Scheduler) Add(spec string, j Job) error {
+	sched, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	typ, err := StructType(j)
+	if err != nil {
+		return err
+	}
+
+	// The entries of the same job type differ by their arguments.
+	args, err := json.Marshal(unwrapJob(j))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(args)
+
+	s.mu.Lock()
+	s.entries = append(s.entries, &Entry{
+		Spec:     spec,
+		Schedule: sched,
+		Job:      j,
+		Next:     sched.Next(time.Now()),
+		key:      "schedule:" + spec + ":" + typ + ":" + hex.EncodeToString(sum[:8]),
+	})
+	s.mu.Unlock()
+
+	// Wake up the loop to take the new entry into account.
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Entries returns a snapshot of the registered entries
+// ordered by the next activation time.
+func (s *Scheduler) Entries() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]Entry, len(s.entries))
+	for i, e := range s.entries {
+		entries[i] = *e
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Next.IsZero() {
+			return false
+		}
+		return entries[j].Next.IsZero() || entries[i].Next.Before(entries[j].Next)
+	})
+
+	return entries
+}
+
+// Run enqueues the jobs in q on schedule until the context is done.
+func (s *Scheduler) Run(ctx context.Context, q Queue) {
+	logger := loggerFromContext(ctx, s.Logger)
+
+	for {
+		timer := time.NewTimer(s.wait(time.Now()))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.changed:
+			timer.Stop()
+		case now := <-timer.C:
+			s.fire(logger, q, now)
+		}
+	}
+}
+
+// wait returns the duration until the next activation.
+func (s *Scheduler) wait(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, e := range s.entries {
+		if !e.Next.IsZero() && (next.IsZero() || e.Next.Before(next)) {
+			next = e.Next
+		}
+	}
+
+	if next.IsZero() {
+		return time.Hour
+	}
+	return next.Sub(now)
+}
+
+// fire enqueues the due jobs and advances their schedules.
+func (s *Scheduler) fire(logger *slog.Logger, q Queue, now time.Time) {
+	var due []Entry
+
+	s.mu.Lock()
+	for _, e := range s.entries {
+		if !e.Next.IsZero() && !e.Next.After(now) {
+			due = append(due, *e)
+			e.Prev = e.Next
+			e.Next = e.Schedule.Next(now)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, e := range due {
+		if err := s.enqueue(q, e); err != nil {
+			logger.Error("Schedule failure", "spec", e.Spec, "error", err)
+		}
+	}
+}
+
+// enqueue puts the entry's job in the queue unless another
+// scheduler has enqueued the same activation already.
+func (s *Scheduler) enqueue(q Queue, e Entry) error {
+	key := e.key + ":" + strconv.FormatInt(e.Next.Unix(), 10)
+	token, err := s.Locker.Lock(key, s.LockTTL)
+	if err != nil || token == "" {
+		return err
+	}
+
+	return q.Put(e.Job)
+}
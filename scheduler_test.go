@@ -0,0 +1,125 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2015, time.April, 24, 14, 36, 21, 0, time.UTC)
+
+	var tests = []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2015, time.April, 24, 14, 37, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2015, time.April, 24, 14, 45, 0, 0, time.UTC)},
+		{"0 9-17 * * mon-fri", time.Date(2015, time.April, 24, 15, 0, 0, 0, time.UTC)},
+		{"30 8 * * sat,sun", time.Date(2015, time.April, 25, 8, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2016, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2015, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2015, time.April, 24, 15, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2015, time.April, 26, 0, 0, 0, 0, time.UTC)},
+		{"@every 5m", time.Date(2015, time.April, 24, 14, 40, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		s, err := worker.ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+
+		if got := s.Next(base); !got.Equal(tt.want) {
+			t.Errorf("%q: expecting %v, got %v", tt.spec, tt.want, got)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "@every 1ms", "@often"} {
+		if _, err := worker.ParseSchedule(spec); err == nil {
+			t.Errorf("%q: expecting an error", spec)
+		}
+	}
+}
+
+func TestParseScheduleDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	s, err := worker.ParseSchedule("*/15 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The wall clock repeats 01:00-02:00 when DST ends,
+	// base is the second 01:30 (EST).
+	base := time.Date(2026, time.November, 1, 1, 30, 0, 0, loc).Add(time.Hour)
+
+	if got, want := s.Next(base), base.Add(15*time.Minute); !got.Equal(want) {
+		t.Errorf("expecting %v, got %v", want, got)
+	}
+
+	// The activations keep moving forward through the repeated hour.
+	next := time.Date(2026, time.November, 1, 0, 50, 0, 0, loc)
+	for i := 0; i < 12; i++ {
+		prev := next
+		if next = s.Next(prev); !next.After(prev) {
+			t.Fatalf("expecting activation after %v, got %v", prev, next)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := worker.NewMemoryQueue()
+	locker := worker.NewMemoryLocker()
+
+	// Two schedulers sharing the same lock store must enqueue
+	// each activation only once, the entries of the same
+	// type with different arguments are enqueued each.
+	var schedulers []*worker.Scheduler
+	for i := 0; i < 2; i++ {
+		s := worker.NewScheduler(func(s *worker.Scheduler) {
+			s.Locker = locker
+		})
+		for _, j := range []*addJob{{X: 1, Y: 2}, {X: 3, Y: 4}} {
+			if err := s.Add("@every 1s", j); err != nil {
+				t.Fatal(err)
+			}
+		}
+		schedulers = append(schedulers, s)
+	}
+
+	entries := schedulers[0].Entries()
+	next := entries[len(entries)-1].Next
+	if d := time.Until(next); d <= 0 || d > time.Second {
+		t.Errorf("expecting next activation within a second, got %v", next)
+	}
+
+	for _, s := range schedulers {
+		go s.Run(ctx, q)
+	}
+
+	time.Sleep(time.Until(next) + 100*time.Millisecond)
+
+	ready, _, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ready != 2 {
+		t.Errorf("expecting size to be %v, got %v", 2, ready)
+	}
+
+	if prev := schedulers[0].Entries()[1].Prev; !prev.Equal(next) {
+		t.Errorf("expecting previous activation %v, got %v", next, prev)
+	}
+}
@@ -6,22 +6,64 @@ import (
 	"syscall"
 )
 
-// trap traps OS signals to allow clean exit.
-func trap() <-chan struct{} {
-	out := make(chan struct{}, 1)
+// SignalPolicy configures the unix signals handled by the pool,
+// signals missing from the policy are left to the application.
+type SignalPolicy struct {
+	Quit   []os.Signal // Drain and stop the pool.
+	Reload []os.Signal // Reapply the reloadable options (see SetReloadable).
+	Pause  []os.Signal // Toggle pause/resume.
+	Dump   []os.Signal // Log the workers state.
+}
+
+var (
+	// DefaultSignalPolicy handles the quit signals only.
+	DefaultSignalPolicy = SignalPolicy{
+		Quit: []os.Signal{syscall.SIGUSR1, syscall.SIGINT, syscall.SIGTERM},
+	}
+
+	// FullSignalPolicy handles the quit, reload (SIGHUP),
+	// pause (SIGUSR2) and dump (SIGQUIT) signals.
+	FullSignalPolicy = SignalPolicy{
+		Quit:   []os.Signal{syscall.SIGUSR1, syscall.SIGINT, syscall.SIGTERM},
+		Reload: []os.Signal{syscall.SIGHUP},
+		Pause:  []os.Signal{syscall.SIGUSR2},
+		Dump:   []os.Signal{syscall.SIGQUIT},
+	}
+
+	// NoSignals disables signal handling, the pool
+	// is stopped by cancelling its context.
+	NoSignals = SignalPolicy{}
+)
+
+// signals returns all the signals of the policy.
+func (sp SignalPolicy) signals() []os.Signal {
+	var all []os.Signal
+	for _, ss := range [][]os.Signal{sp.Quit, sp.Reload, sp.Pause, sp.Dump} {
+		all = append(all, ss...)
+	}
+	return all
+}
+
+// trap relays the policy signals through the returned channel
+// until stop is called, the channel is nil if there are none.
+func (sp SignalPolicy) trap() (<-chan os.Signal, func()) {
+	all := sp.signals()
+	if len(all) == 0 {
+		return nil, func() {}
+	}
+
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		defer close(out)
-		for s := range signals {
-			switch s {
-			case syscall.SIGINT, syscall.SIGUSR1, syscall.SIGTERM:
-				out <- struct{}{}
-				return
-			}
-		}
-	}()
+	signal.Notify(signals, all...)
 
-	return out
+	return signals, func() { signal.Stop(signals) }
+}
+
+// has reports whether s is one of the signals.
+func has(signals []os.Signal, s os.Signal) bool {
+	for _, v := range signals {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,448 @@
+package worker
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLDialect represents the SQL flavor used by SQLQueue.
+type SQLDialect int
+
+const (
+	SQLite   SQLDialect = iota // SQLite, rows are leased by atomic updates.
+	Postgres                   // Postgres, rows are locked using SKIP LOCKED.
+)
+
+const (
+	SQLTable = "worker_jobs" // SQL default jobs table.
+	SQLName  = "default"     // SQL default queue.
+	SQLPrio  = 100           // SQL default job priority.
+
+	sqlReady    = "ready"
+	sqlReserved = "reserved"
+	sqlFailed   = "failed"
+)
+
+var (
+	SQLTimeout      time.Duration = 1 * time.Second        // SQL reserve timeout.
+	SQLPollInterval time.Duration = 100 * time.Millisecond // SQL table polling interval.
+	SQLTTR          time.Duration = 2 * DefaultTTR         // SQL default TTR (time to run).
+)
+
+// sqlMessage represents data returned by Get.
+type sqlMessage struct {
+	id        uint64 // Message ID.
+	Lease     int64  // Lease expiration (unix milliseconds), zero if not reserved.
+	*Envelope        // Holds parsed json.
+}
+
+// newSQLMessage returns an instance of sqlMessage.
+func newSQLMessage(c Codec, id uint64, lease int64, payload []byte) (*sqlMessage, error) {
+	base, err := decodeEnvelope(c, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &sqlMessage{
+		id:       id,
+		Lease:    lease,
+		Envelope: base,
+	}
+
+	return env, nil
+}
+
+// ID returns the backend ID of the message.
+func (m *sqlMessage) ID() string { return strconv.FormatUint(m.id, 10) }
+
+// sqlExecer is implemented by both *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLQueue represents a queue stored in a SQL table, several
+// queues can share the table (see Name). Reserved rows are leased
+// for TTR, the rows of the crashed workers become ready again once
+// their lease expires. The messages whose lease expired can't be
+// deleted or updated, the row may be reserved by another worker.
+type SQLQueue struct {
+	DB      *sql.DB       // SQL database.
+	Dialect SQLDialect    // SQL flavor.
+	Table   string        // SQL jobs table.
+	Name    string        // SQL queue name.
+	Prio    uint32        // SQL priority.
+	TTR     time.Duration // SQL time to run.
+	Locker  Locker        // Unique jobs lock store.
+	Codec   Codec         // Payloads codec.
+}
+
+// NewSQLQueue returns a queue instance using custom options,
+// the table must exist already (see Migrate).
+func NewSQLQueue(db *sql.DB, opts ...func(*SQLQueue)) (Queue, error) {
+	q := &SQLQueue{
+		DB:      db,
+		Dialect: SQLite,
+		Table:   SQLTable,
+		Name:    SQLName,
+		Prio:    SQLPrio,
+		TTR:     SQLTTR,
+		Locker:  NewMemoryLocker(),
+		Codec:   JSONCodec,
+	}
+
+	// Apply options.
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q, nil
+}
+
+// SQLSchema returns the statements creating the jobs table,
+// times are stored as unix milliseconds.
+func SQLSchema(d SQLDialect, table string) []string {
+	id, body := "INTEGER PRIMARY KEY AUTOINCREMENT", "BLOB"
+	if d == Postgres {
+		id, body = "BIGSERIAL PRIMARY KEY", "BYTEA"
+	}
+
+	return []string{
+		"CREATE TABLE IF NOT EXISTS " + table + " (" +
+			"id " + id + ", " +
+			"queue VARCHAR(255) NOT NULL, " +
+			"status VARCHAR(16) NOT NULL, " +
+			"priority BIGINT NOT NULL, " +
+			"run_at BIGINT NOT NULL, " +
+			"attempts INTEGER NOT NULL DEFAULT 0, " +
+			"locked_until BIGINT, " +
+			"body " + body + " NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS " + table + "_reserve ON " + table + " (queue, status, priority, run_at)",
+	}
+}
+
+// Migrate creates the jobs table if it doesn't exist.
+func (q *SQLQueue) Migrate() error {
+	for _, stmt := range SQLSchema(q.Dialect, q.Table) {
+		if _, err := q.DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put puts the job in the queue.
+func (q *SQLQueue) Put(j Job) error {
+	return q.put(q.DB, j, 0)
+}
+
+// PutIn puts the job in the queue, the job becomes
+// ready after the delay elapses.
+func (q *SQLQueue) PutIn(j Job, delay time.Duration) error {
+	return q.put(q.DB, j, delay)
+}
+
+// PutAt puts the job in the queue, the job
+// becomes ready at the specified time.
+func (q *SQLQueue) PutAt(j Job, t time.Time) error {
+	return q.put(q.DB, j, time.Until(t))
+}
+
+// PutTx puts the job in the queue using the transaction, the job
+// is visible to workers only after the transaction commits. The
+// uniqueness lock of a rolled back job is held until it expires.
+func (q *SQLQueue) PutTx(tx *sql.Tx, j Job) error {
+	return q.put(tx, j, 0)
+}
+
+func (q *SQLQueue) put(db sqlExecer, j Job, delay time.Duration) error {
+	prio := q.Prio
+
+	if v, ok := unwrapJob(j).(Priority); ok {
+		prio = v.Prio()
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+
+	return putUnique(q.Locker, q.Codec, j, func(body []byte) error {
+		_, err := db.Exec(q.query(
+			"INSERT INTO ", q.Table, " (queue, status, priority, run_at, attempts, body) VALUES (?, ?, ?, ?, 0, ?)"),
+			q.Name, sqlReady, int64(prio), time.Now().Add(delay).UnixMilli(), body)
+		return err
+	})
+}
+
+// Get reserves a job from the queue, the job is leased for TTR.
+func (q *SQLQueue) Get() (Message, error) {
+	deadline := time.Now().Add(SQLTimeout)
+
+	for {
+		msg, err := q.reserve()
+		if err != nil || msg != nil {
+			return msg, err
+		}
+
+		if time.Now().Add(SQLPollInterval).After(deadline) {
+			return nil, &Error{Err: "timeout", IsTimeout: true}
+		}
+		time.Sleep(SQLPollInterval)
+	}
+}
+
+// reserve leases the most urgent ready row, ready rows include
+// the reserved ones whose lease expired, nil is returned if
+// there is none.
+func (q *SQLQueue) reserve() (Message, error) {
+	now := time.Now()
+
+	lock := ""
+	if q.Dialect == Postgres {
+		lock = " FOR UPDATE SKIP LOCKED"
+	}
+
+	lease := now.Add(q.TTR).UnixMilli()
+	row := q.DB.QueryRow(q.query(
+		"UPDATE ", q.Table, " SET status = ?, locked_until = ? WHERE id = (",
+		"SELECT id FROM ", q.Table, " WHERE queue = ? AND (",
+		"(status = ? AND run_at <= ?) OR (status = ? AND locked_until <= ?)) ",
+		"ORDER BY priority, run_at, id LIMIT 1", lock, ") RETURNING id, body"),
+		sqlReserved, lease,
+		q.Name, sqlReady, now.UnixMilli(), sqlReserved, now.UnixMilli())
+
+	var id uint64
+	var body []byte
+	if err := row.Scan(&id, &body); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return newSQLMessage(q.Codec, id, lease, body)
+}
+
+// Delete deletes a job from the queue.
+func (q *SQLQueue) Delete(m Message) error {
+	env, ok := m.(*sqlMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	res, err := q.DB.Exec(q.query(
+		"DELETE FROM ", q.Table, " WHERE id = ? AND status = ? AND locked_until = ?"),
+		env.id, sqlReserved, env.Lease)
+	if err != nil {
+		return err
+	}
+
+	if err := leased(res, env.id); err != nil {
+		return err
+	}
+
+	return unlockUnique(q.Locker, env.Envelope)
+}
+
+// Release puts the job back in the queue incrementing its attempts
+// counter, the job becomes ready after the delay elapses.
+func (q *SQLQueue) Release(m Message, delay time.Duration) error {
+	env, ok := m.(*sqlMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	env.incAttempts()
+	return q.Defer(env, delay)
+}
+
+// Defer puts the job back in the queue without counting
+// an attempt, the job becomes ready after the delay elapses.
+func (q *SQLQueue) Defer(m Message, delay time.Duration) error {
+	env, ok := m.(*sqlMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	return q.update(env, sqlReady, time.Now().Add(delay))
+}
+
+// Reject rejects the job marking it as failed.
+func (q *SQLQueue) Reject(m Message, reason error) error {
+	env, ok := m.(*sqlMessage)
+	if !ok {
+		return NewErrorFmt("bad envelope: %v", m)
+	}
+
+	env.reject(reason)
+	if err := q.update(env, sqlFailed, time.Now()); err != nil {
+		return err
+	}
+
+	return unlockUnique(q.Locker, env.Envelope)
+}
+
+// Size returns the number of ready and failed jobs.
+func (q *SQLQueue) Size() (uint64, uint64, error) {
+	var ready, failed uint64
+
+	row := q.DB.QueryRow(q.query(
+		"SELECT COUNT(*) FROM ", q.Table, " WHERE queue = ? AND status = ? AND run_at <= ?"),
+		q.Name, sqlReady, time.Now().UnixMilli())
+	if err := row.Scan(&ready); err != nil {
+		return 0, 0, err
+	}
+
+	row = q.DB.QueryRow(q.query(
+		"SELECT COUNT(*) FROM ", q.Table, " WHERE queue = ? AND status = ?"),
+		q.Name, sqlFailed)
+	if err := row.Scan(&failed); err != nil {
+		return 0, 0, err
+	}
+
+	return ready, failed, nil
+}
+
+// ListFailed returns up to limit failed jobs, all the
+// failed jobs are returned if limit is not positive.
+func (q *SQLQueue) ListFailed(limit int) ([]*FailedJob, error) {
+	query := q.query("SELECT id, body FROM ", q.Table, " WHERE queue = ? AND status = ? ORDER BY id")
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+
+	rows, err := q.DB.Query(query, q.Name, sqlFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*FailedJob
+	for rows.Next() {
+		var id uint64
+		var body []byte
+		if err := rows.Scan(&id, &body); err != nil {
+			return nil, err
+		}
+
+		env, err := decodeEnvelope(q.Codec, body)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, env.failed(id))
+	}
+
+	return jobs, rows.Err()
+}
+
+// Requeue moves the failed job back to the queue.
+func (q *SQLQueue) Requeue(id uint64) error {
+	var body []byte
+	row := q.DB.QueryRow(q.query(
+		"SELECT body FROM ", q.Table, " WHERE id = ? AND queue = ? AND status = ?"),
+		id, q.Name, sqlFailed)
+	if err := row.Scan(&body); err == sql.ErrNoRows {
+		return NewErrorFmt("job %v not found", id)
+	} else if err != nil {
+		return err
+	}
+
+	env, err := newSQLMessage(q.Codec, id, 0, body)
+	if err != nil {
+		return err
+	}
+
+	env.reset()
+	return q.update(env, sqlReady, time.Now())
+}
+
+// RequeueAll moves all the failed jobs back to the queue.
+func (q *SQLQueue) RequeueAll() (int, error) {
+	jobs, err := q.ListFailed(0)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, job := range jobs {
+		if err := q.Requeue(job.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(jobs), nil
+}
+
+// Purge deletes the failed job.
+func (q *SQLQueue) Purge(id uint64) error {
+	res, err := q.DB.Exec(q.query(
+		"DELETE FROM ", q.Table, " WHERE id = ? AND queue = ? AND status = ?"),
+		id, q.Name, sqlFailed)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return NewErrorFmt("job %v not found", id)
+	}
+	return nil
+}
+
+// update stores the message body and its new status, the row
+// must be leased by the message or failed if it's not reserved.
+func (q *SQLQueue) update(env *sqlMessage, status string, runAt time.Time) error {
+	body, err := encodeEnvelope(q.Codec, env.Envelope)
+	if err != nil {
+		return err
+	}
+
+	query := q.query(
+		"UPDATE ", q.Table, " SET status = ?, run_at = ?, attempts = ?, locked_until = NULL, body = ? ",
+		"WHERE id = ? AND status = ? AND locked_until = ?")
+	args := []interface{}{status, runAt.UnixMilli(), env.Attempts(), body, env.id, sqlReserved, env.Lease}
+	if env.Lease == 0 {
+		query = q.query(
+			"UPDATE ", q.Table, " SET status = ?, run_at = ?, attempts = ?, locked_until = NULL, body = ? ",
+			"WHERE id = ? AND status = ?")
+		args = args[:6]
+		args[5] = sqlFailed
+	}
+
+	res, err := q.DB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return leased(res, env.id)
+}
+
+// leased checks the statement affected the row of the job.
+func leased(res sql.Result, id uint64) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return NewErrorFmt("job %v: lease expired", id)
+	}
+	return nil
+}
+
+// query joins the parts and rebinds the placeholders for the dialect.
+func (q *SQLQueue) query(parts ...string) string {
+	s := strings.Join(parts, "")
+	if q.Dialect != Postgres {
+		return s
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range s {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package sqltest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vitalie/worker"
+)
+
+// recorder is a database/sql driver recording the statements,
+// the reserve statement returns the last inserted body.
+type recorder struct {
+	mu    sync.Mutex
+	stmts []string
+	body  []byte
+}
+
+func (r *recorder) Open(name string) (driver.Conn, error) { return &recorderConn{r}, nil }
+
+func (r *recorder) record(query string, args []driver.Value) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stmts = append(r.stmts, query)
+	if strings.HasPrefix(query, "INSERT") {
+		r.body = args[len(args)-1].([]byte)
+	}
+}
+
+var placeholder = regexp.MustCompile(`\$[0-9]+`)
+
+type recorderConn struct{ r *recorder }
+
+func (c *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return &recorderStmt{c.r, query}, nil
+}
+
+func (c *recorderConn) Close() error { return nil }
+
+func (c *recorderConn) Begin() (driver.Tx, error) { return c, nil }
+
+func (c *recorderConn) Commit() error { return nil }
+
+func (c *recorderConn) Rollback() error { return nil }
+
+type recorderStmt struct {
+	r     *recorder
+	query string
+}
+
+func (s *recorderStmt) Close() error { return nil }
+
+func (s *recorderStmt) NumInput() int { return -1 }
+
+func (s *recorderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.r.record(s.query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recorderStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.r.record(s.query, args)
+	return &recorderRows{body: s.r.body}, nil
+}
+
+type recorderRows struct {
+	body []byte
+	done bool
+}
+
+func (r *recorderRows) Columns() []string { return []string{"id", "body"} }
+
+func (r *recorderRows) Close() error { return nil }
+
+func (r *recorderRows) Next(dest []driver.Value) error {
+	if r.done || r.body == nil {
+		return io.EOF
+	}
+	r.done = true
+	dest[0], dest[1] = int64(1), r.body
+	return nil
+}
+
+func TestSQLQueuePostgres(t *testing.T) {
+	rec := &recorder{}
+	sql.Register("recorder", rec)
+
+	db, err := sql.Open("recorder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	q, err := worker.NewSQLQueue(db, func(q *worker.SQLQueue) {
+		q.Dialect = worker.Postgres
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Put(&addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Delete(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.stmts) != 3 {
+		t.Fatalf("expecting %v statements, got %v", 3, len(rec.stmts))
+	}
+
+	// The placeholders are numbered in order.
+	for _, stmt := range rec.stmts {
+		if strings.Contains(stmt, "?") {
+			t.Errorf("expecting rebound placeholders, got %q", stmt)
+		}
+
+		for i, m := range placeholder.FindAllString(stmt, -1) {
+			if m != "$"+strconv.Itoa(i+1) {
+				t.Errorf("expecting $%v, got %v in %q", i+1, m, stmt)
+			}
+		}
+	}
+
+	if !strings.Contains(rec.stmts[1], "FOR UPDATE SKIP LOCKED") {
+		t.Errorf("expecting row locks, got %q", rec.stmts[1])
+	}
+}
@@ -0,0 +1,202 @@
+// Package sqltest tests the SQL queue using SQLite, it's a separate
+// module so the driver isn't a dependency of the worker module.
+package sqltest
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+	_ "modernc.org/sqlite"
+)
+
+// addJob represents a test job.
+type addJob struct {
+	X, Y int
+}
+
+func (j *addJob) Make(args *worker.Args) (worker.Job, error) { return &addJob{}, nil }
+
+func (j *addJob) Run() error { return nil }
+
+// prioJob represents a test job with a custom priority.
+type prioJob struct {
+	X int
+	P uint32
+}
+
+func (j *prioJob) Make(args *worker.Args) (worker.Job, error) { return &prioJob{}, nil }
+
+func (j *prioJob) Run() error { return nil }
+
+func (j *prioJob) Prio() uint32 { return j.P }
+
+// newSQLQueue returns a queue stored in a temporary SQLite file.
+func newSQLQueue(t *testing.T, opts ...func(*worker.SQLQueue)) (worker.Queue, *sql.DB) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	q, err := worker.NewSQLQueue(db, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.(*worker.SQLQueue).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return q, db
+}
+
+func TestSQLQueue(t *testing.T) {
+	q, _ := newSQLQueue(t)
+
+	for i, p := range []uint32{200, 10} {
+		if err := q.Put(&prioJob{X: i, P: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	size, _, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if size != 2 {
+		t.Errorf("expecting size to be %v, got %v", 2, size)
+	}
+
+	for _, want := range []int{1, 0} {
+		msg, err := q.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if x := msg.Args().Get("X").MustInt(-1); x != want {
+			t.Errorf("expecting X to be %v, got %v", want, x)
+		}
+
+		if err := q.Delete(msg); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := q.Get(); err == nil {
+		t.Error("expecting empty queue")
+	}
+}
+
+func TestSQLQueuePutTx(t *testing.T) {
+	q, db := newSQLQueue(t)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.(*worker.SQLQueue).PutTx(tx, &addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, _, _ := q.Size(); size != 0 {
+		t.Errorf("expecting size to be %v, got %v", 0, size)
+	}
+
+	if tx, err = db.Begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.(*worker.SQLQueue).PutTx(tx, &addJob{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, _, _ := q.Size(); size != 1 {
+		t.Errorf("expecting size to be %v, got %v", 1, size)
+	}
+}
+
+func TestSQLQueueLease(t *testing.T) {
+	q, _ := newSQLQueue(t, func(q *worker.SQLQueue) {
+		q.TTR = 100 * time.Millisecond
+	})
+
+	if err := q.PutIn(&addJob{X: 1, Y: 2}, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lease of the abandoned message expires after TTR.
+	stale := msg
+	msg, err = q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stale message can't change the row reserved again.
+	if err := q.Delete(stale); err == nil {
+		t.Error("expecting expired lease error")
+	}
+
+	if err := q.Reject(stale, errors.New("boom")); err == nil {
+		t.Error("expecting expired lease error")
+	}
+
+	if err := q.Release(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg, err = q.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Reject(msg, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	dl := q.(worker.DeadLetter)
+	jobs, err := dl.ListFailed(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 1 {
+		t.Fatalf("expecting %v jobs, got %v", 1, len(jobs))
+	}
+
+	if job := jobs[0]; job.Attempts != 1 || job.Failure.Err != "boom" {
+		t.Errorf("expecting 1 attempt failed with boom, got %v failed with %v", job.Attempts, job.Failure)
+	}
+
+	if n, err := dl.RequeueAll(); err != nil || n != 1 {
+		t.Errorf("expecting %v requeued jobs, got %v (%v)", 1, n, err)
+	}
+
+	ready, failed, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ready != 1 || failed != 0 {
+		t.Errorf("expecting (1, 0), got (%v, %v)", ready, failed)
+	}
+}
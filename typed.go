@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+// argsJob is implemented by the typed jobs, the arguments
+// are enqueued in place of the job.
+type argsJob interface {
+	jobArgs() interface{}
+}
+
+// typedFactory decodes the arguments of a typed job.
+type typedFactory[T any] struct {
+	run    func(context.Context, T) error
+	strict bool
+}
+
+func (f *typedFactory[T]) Make(args *Args) (Job, error) {
+	body, err := args.MarshalJSON()
+	if err != nil {
+		return nil, NewPermanentError(err)
+	}
+
+	var v T
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if f.strict {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(&v); err != nil {
+		return nil, NewPermanentError(NewErrorFmt("decode: %v", err))
+	}
+
+	return WithContext(&typedJob[T]{args: v, run: f.run}), nil
+}
+
+// typedJob represents a job built from its typed arguments.
+type typedJob[T any] struct {
+	args T
+	run  func(context.Context, T) error
+}
+
+// NewJob returns a job enqueuing the arguments,
+// the job type is the arguments type (see Register).
+func NewJob[T any](args T) Job {
+	return WithContext(&typedJob[T]{args: args})
+}
+
+func (f *typedFactory[T]) JobVersion() int {
+	var v T
+	return jobVersion(v)
+}
+
+func (j *typedJob[T]) Make(args *Args) (ContextJob, error) {
+	f := &typedFactory[T]{run: j.run}
+	c, err := f.Make(args)
+	if err != nil {
+		return nil, err
+	}
+	return c.(*contextJob).job, nil
+}
+
+func (j *typedJob[T]) Run(ctx context.Context) error {
+	if j.run == nil {
+		return NewError("not registered")
+	}
+	return j.run(ctx, j.args)
+}
+
+func (j *typedJob[T]) jobArgs() interface{} {
+	return j.args
+}
+
+// Register registers the run function of the jobs having T arguments,
+// the arguments are decoded from the message with encoding/json and
+// the decoding errors fail the job permanently. The job type is the
+// T struct name, the jobs are enqueued using NewJob.
+func Register[T any](p *Pool, run func(context.Context, T) error, opts ...func(*FactoryOptions)) error {
+	var args T
+	typ, err := StructType(args)
+	if err != nil {
+		return err
+	}
+
+	var o FactoryOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return p.add(typ, &typedFactory[T]{run: run, strict: o.Strict}, opts...)
+}
@@ -0,0 +1,290 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vitalie/worker"
+)
+
+// signupArgs represents the arguments of a typed test job.
+type signupArgs struct {
+	Email string
+}
+
+func TestRegister(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	policy := worker.NewExponentialBackoff(3)
+	policy.Min = time.Millisecond
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetRetryPolicy(policy),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+
+	emails := make(chan string, 10)
+	err := worker.Register(pool, func(ctx context.Context, args signupArgs) error {
+		emails <- args.Email
+		return nil
+	}, worker.Strict())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := worker.Register(pool, func(ctx context.Context, args signupArgs) error { return nil }); err == nil {
+		t.Error("expecting duplicate registration error")
+	}
+
+	// An old shaped payload with a misspelled argument.
+	type signupArgs struct {
+		Email string
+		Typo  string
+	}
+
+	if err := q.Put(worker.NewJob(signupArgs{Typo: "b@example.com"})); err != nil {
+		t.Fatal(err)
+	}
+
+	go pool.Run(ctx)
+
+	if err := q.Put(worker.NewJob(newSignup("a@example.com"))); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case email := <-emails:
+		if email != "a@example.com" {
+			t.Errorf("expecting %q, got %q", "a@example.com", email)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("typed job didn't run")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	jobs, err := q.(worker.DeadLetter).ListFailed(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding failures aren't retried.
+	if len(jobs) != 1 || jobs[0].Attempts != 0 || !strings.Contains(jobs[0].Failure.Err, "unknown field") {
+		t.Fatalf("expecting permanent decode failure, got %+v", jobs)
+	}
+}
+
+// newSignup returns the current shape of the typed test job.
+func newSignup(email string) signupArgs {
+	return signupArgs{Email: email}
+}
+
+var cents chan int = make(chan int, 10)
+
+// invoiceJob represents a job having a versioned payload.
+type invoiceJob struct {
+	Cents int
+}
+
+func (j *invoiceJob) JobType() string { return "billing.invoice" }
+
+func (j *invoiceJob) JobVersion() int { return 2 }
+
+func (j *invoiceJob) Make(args *worker.Args) (worker.Job, error) {
+	return &invoiceJob{Cents: args.Get("Cents").MustInt(-1)}, nil
+}
+
+func (j *invoiceJob) Run() error {
+	cents <- j.Cents
+	return nil
+}
+
+// oldInvoiceJob represents the first shape of invoiceJob.
+type oldInvoiceJob struct {
+	Amount float64
+}
+
+func (j *oldInvoiceJob) JobType() string { return "billing.invoice" }
+
+func (j *oldInvoiceJob) Make(args *worker.Args) (worker.Job, error) { return j, nil }
+
+func (j *oldInvoiceJob) Run() error { return nil }
+
+// newInvoiceJob represents a shape of invoiceJob unknown to the pool.
+type newInvoiceJob struct {
+	Cents    int
+	Currency string
+}
+
+func (j *newInvoiceJob) JobType() string { return "billing.invoice" }
+
+func (j *newInvoiceJob) JobVersion() int { return 3 }
+
+func (j *newInvoiceJob) Make(args *worker.Args) (worker.Job, error) { return j, nil }
+
+func (j *newInvoiceJob) Run() error { return nil }
+
+func TestUpgrade(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+
+	if err := pool.Add(&invoiceJob{}); err == nil {
+		t.Error("expecting missing upgrades error")
+	}
+
+	err := pool.AddWithOptions(&invoiceJob{}, worker.Upgrade(0, func(args *worker.Args) error { return nil }))
+	if err == nil {
+		t.Error("expecting missing upgrade error")
+	}
+
+	err = pool.AddWithOptions(&invoiceJob{},
+		worker.Upgrade(0, func(args *worker.Args) error {
+			args.Set("Total", args.Get("Amount").MustFloat64(0))
+			args.Del("Amount")
+			return nil
+		}),
+		worker.Upgrade(1, func(args *worker.Args) error {
+			args.Set("Cents", int(args.Get("Total").MustFloat64(0)*100))
+			return nil
+		}),
+		func(o *worker.FactoryOptions) { o.DeferDelay = time.Hour },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.Add(&oldInvoiceJob{}); err == nil {
+		t.Error("expecting duplicate type error")
+	}
+
+	if err := q.Put(&oldInvoiceJob{Amount: 1.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Put(&invoiceJob{Cents: 200}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Put(&newInvoiceJob{Cents: 300, Currency: "EUR"}); err != nil {
+		t.Fatal(err)
+	}
+
+	go pool.Run(ctx)
+
+	got := map[int]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case n := <-cents:
+			got[n] = true
+		case <-time.After(time.Second):
+			t.Fatal("invoice job didn't run")
+		}
+	}
+
+	// The old payload is migrated before Make runs.
+	if !got[150] || !got[200] {
+		t.Errorf("expecting 150 and 200 cents, got %v", got)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	// The newer payload is deferred instead of being rejected.
+	_, failed, err := q.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failed != 0 {
+		t.Errorf("expecting failed to be %v, got %v", 0, failed)
+	}
+}
+
+var refunds chan int = make(chan int, 10)
+
+// refundJob represents a versioned job failing its first run.
+type refundJob struct {
+	Cents int
+	runs  *atomic.Int32
+}
+
+var refundRuns atomic.Int32
+
+func (j *refundJob) JobVersion() int { return 1 }
+
+func (j *refundJob) Make(args *worker.Args) (worker.Job, error) {
+	return &refundJob{Cents: args.Get("Cents").MustInt(-1), runs: &refundRuns}, nil
+}
+
+func (j *refundJob) Run() error {
+	refunds <- j.Cents
+	if j.runs.Add(1) == 1 {
+		return errors.New("failure")
+	}
+	return nil
+}
+
+// oldRefundJob represents the first shape of refundJob.
+type oldRefundJob struct {
+	Amount float64
+}
+
+func (j *oldRefundJob) JobType() string { return "refundJob" }
+
+func (j *oldRefundJob) Make(args *worker.Args) (worker.Job, error) { return j, nil }
+
+func (j *oldRefundJob) Run() error { return nil }
+
+func TestUpgradeRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	policy := worker.NewExponentialBackoff(3)
+	policy.Min = time.Millisecond
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetRetryPolicy(policy),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+
+	err := pool.AddWithOptions(&refundJob{}, worker.Upgrade(0, func(args *worker.Args) error {
+		args.Set("Cents", int(args.Get("Amount").MustFloat64(0)*100))
+		args.Del("Amount")
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Put(&oldRefundJob{Amount: 1.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	go pool.Run(ctx)
+
+	// The retry migrates the original payload again.
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-refunds:
+			if got != 150 {
+				t.Errorf("run %v: expecting %v cents, got %v", i+1, 150, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expecting %v runs, got %v", 2, i)
+		}
+	}
+}
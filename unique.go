@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"time"
+)
+
+// ErrDuplicate is returned by Put when an equivalent
+// unique job is queued or running already.
+var ErrDuplicate = NewError("duplicate job")
+
+// Unique is implemented by jobs which must not be queued twice,
+// the job is unique until it's deleted or rejected but no longer
+// than the UniqueFor duration.
+type Unique interface {
+	UniqueKey() string
+	UniqueFor() time.Duration
+}
+
+// lockUnique acquires the uniqueness lock of the job, it returns
+// the lock key and token or empty strings if the job is not unique.
+func lockUnique(l Locker, j Job) (string, string, error) {
+	u, ok := unwrapJob(j).(Unique)
+	if !ok || l == nil {
+		return "", "", nil
+	}
+
+	typ, err := StructType(j)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := "unique:" + typ + ":" + u.UniqueKey()
+	token, err := l.Lock(key, u.UniqueFor())
+	if err != nil {
+		return "", "", err
+	}
+
+	if token == "" {
+		return "", "", ErrDuplicate
+	}
+	return key, token, nil
+}
+
+// putUnique encodes the job and stores it using put, the
+// uniqueness lock is released if the job can't be stored.
+func putUnique(l Locker, c Codec, j Job, put func(body []byte) error) error {
+	key, token, err := lockUnique(l, j)
+	if err != nil {
+		return err
+	}
+
+	body, err := marshalJob(j, key, token)
+	if err == nil {
+		body, err = encodeBody(c, body)
+	}
+	if err == nil {
+		err = put(body)
+	}
+
+	if err != nil && key != "" {
+		l.Unlock(key, token)
+	}
+	return err
+}
+
+// unlockUnique releases the uniqueness lock of the message.
+func unlockUnique(l Locker, e *Envelope) error {
+	key := e.Get("unique").MustString("")
+	if key == "" || l == nil {
+		return nil
+	}
+	return l.Unlock(key, e.Get("unique_token").MustString(""))
+}
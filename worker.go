@@ -1,9 +1,21 @@
 package worker
 
+import (
+	"context"
+)
+
 type Runner interface {
 	Run() error
 }
 
+// ContextRunner is implemented by jobs which want to observe the
+// job context, the context is cancelled when the job exceeds its
+// time to run or when the pool shuts down. Its Run method excludes
+// Runner, the jobs are wrapped by WithContext (see ContextJob).
+type ContextRunner interface {
+	Run(ctx context.Context) error
+}
+
 type Factory interface {
 	Make(*Args) (Job, error)
 }
@@ -13,6 +25,52 @@ type Job interface {
 	Factory
 }
 
+// ContextJob represents a background job observing the job
+// context. It doesn't implement Job, it must be wrapped by
+// WithContext both when it's registered (Add) and enqueued (Put).
+type ContextJob interface {
+	ContextRunner
+	Make(*Args) (ContextJob, error)
+}
+
+// contextJob adapts a ContextJob to the Job interface.
+type contextJob struct {
+	job ContextJob
+}
+
+// WithContext returns the job running j with the job context,
+// the jobs implementing ContextJob are enqueued and registered
+// using the returned job.
+func WithContext(j ContextJob) Job {
+	return &contextJob{job: j}
+}
+
+func (c *contextJob) Make(args *Args) (Job, error) {
+	j, err := c.job.Make(args)
+	if err != nil {
+		return nil, err
+	}
+	return &contextJob{job: j}, nil
+}
+
+// Run runs the job outside of a job context.
+func (c *contextJob) Run() error {
+	return c.job.Run(context.Background())
+}
+
+// TypeNamer is implemented by the jobs declaring their wire type,
+// by default the type is the struct name (see StructType).
+type TypeNamer interface {
+	JobType() string
+}
+
+// Versioner is implemented by the jobs declaring their payload
+// version, older payloads are migrated by the type upgrade
+// functions before Make runs (see Upgrade).
+type Versioner interface {
+	JobVersion() int
+}
+
 type Priority interface {
 	Prio() uint32
 }
@@ -2,8 +2,14 @@ package worker_test
 
 import (
 	"context"
+	"errors"
 	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/vitalie/worker"
 )
@@ -88,4 +94,484 @@ func TestPool(t *testing.T) {
 	if failed != 1 {
 		t.Errorf("expecting failed to be %v, got %v", 1, failed)
 	}
+
+	jobs, err := q.(worker.DeadLetter).ListFailed(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f := jobs[0].Failure; f == nil || !f.Panic || f.Stack == "" || f.Time.IsZero() {
+		t.Errorf("expecting panic failure details, got %+v", f)
+	}
+}
+
+var errc chan error = make(chan error, 1)
+
+// waitJob represents a context aware job which blocks
+// until the job context is done.
+type waitJob struct{}
+
+func (j *waitJob) Make(args *worker.Args) (worker.ContextJob, error) { return &waitJob{}, nil }
+
+func (j *waitJob) Run(ctx context.Context) error {
+	<-ctx.Done()
+	errc <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestPoolContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetTTR(50*time.Millisecond),
+	)
+	pool.Add(worker.WithContext(&waitJob{}))
+
+	go pool.Run(ctx)
+
+	if err := q.Put(worker.WithContext(&waitJob{})); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errc:
+		if err != context.DeadlineExceeded {
+			t.Errorf("expecting %v, got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job context was not cancelled")
+	}
+}
+
+var runs chan int = make(chan int, 10)
+
+// failJob represents a job which always fails.
+type failJob struct{}
+
+func (j *failJob) Make(args *worker.Args) (worker.Job, error) { return &failJob{}, nil }
+
+func (j *failJob) Run() error {
+	runs <- 1
+	return errors.New("failure")
+}
+
+func TestPoolRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	policy := worker.NewExponentialBackoff(3)
+	policy.Min = time.Millisecond
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetRetryPolicy(policy),
+	)
+	pool.Add(&failJob{})
+
+	go pool.Run(ctx)
+
+	if err := q.Put(&failJob{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < policy.MaxAttempts; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatalf("expecting %v runs, got %v", policy.MaxAttempts, i)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	_, failed, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if failed != 1 {
+		t.Errorf("expecting failed to be %v, got %v", 1, failed)
+	}
+}
+
+// retryJob represents a context aware job which always
+// fails, it overrides the pool retry policy.
+type retryJob struct{}
+
+func (j *retryJob) Make(args *worker.Args) (worker.ContextJob, error) { return &retryJob{}, nil }
+
+func (j *retryJob) Run(ctx context.Context) error {
+	runs <- 1
+	return errors.New("failure")
+}
+
+func (j *retryJob) RetryPolicy() worker.RetryPolicy {
+	policy := worker.NewExponentialBackoff(3)
+	policy.Min = time.Millisecond
+	return policy
+}
+
+func TestPoolRetryContextJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(worker.SetQueue(q))
+	pool.Add(worker.WithContext(&retryJob{}))
+
+	go pool.Run(ctx)
+
+	if err := q.Put(worker.WithContext(&retryJob{})); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatalf("expecting %v runs, got %v", 3, i)
+		}
+	}
+}
+
+var started chan int = make(chan int, 10)
+
+// sleepJob represents a context aware job which
+// sleeps for D milliseconds.
+type sleepJob struct {
+	D int
+}
+
+func (j *sleepJob) Make(args *worker.Args) (worker.ContextJob, error) {
+	return &sleepJob{D: args.Get("D").MustInt(0)}, nil
+}
+
+func (j *sleepJob) Run(ctx context.Context) error {
+	started <- j.D
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(j.D) * time.Millisecond):
+		return nil
+	}
+}
+
+func TestPoolDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetWorkers(2),
+		worker.SetShutdownTimeout(200*time.Millisecond),
+	)
+	pool.Add(worker.WithContext(&sleepJob{}))
+
+	errc := make(chan error, 1)
+	go func() { errc <- pool.Run(ctx) }()
+
+	for _, d := range []int{150, 10000} {
+		if err := q.Put(worker.WithContext(&sleepJob{D: d})); err != nil {
+			t.Fatal(err)
+		}
+		<-started
+	}
+
+	// The short job finishes while draining, the
+	// long one is released once the timeout elapses.
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("expecting %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pool was not stopped")
+	}
+
+	ready, failed, err := q.Size()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ready != 1 || failed != 0 {
+		t.Fatalf("expecting (1, 0), got (%v, %v)", ready, failed)
+	}
+
+	msg, err := q.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := msg.Args().Get("D").MustInt(-1); d != 10000 {
+		t.Errorf("expecting the long job to be released, got %v", d)
+	}
+
+	// The shutdown doesn't use up a retry attempt.
+	if msg.Attempts() != 0 {
+		t.Errorf("expecting attempts to be %v, got %v", 0, msg.Attempts())
+	}
+}
+
+func TestPoolReloadMiddleware(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	tag := "a"
+	tags := make(chan string, 10)
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetSignalPolicy(worker.NoSignals),
+		worker.SetReloadable(func(p *worker.Pool) {
+			mu.Lock()
+			name := tag
+			mu.Unlock()
+
+			worker.SetMiddleware(worker.HandlerFunc(func(ctx context.Context, sw worker.StatusWriter, fact string, args *worker.Args, next worker.JobRunner) {
+				tags <- name
+				next(ctx, sw, fact, args)
+			}))(p)
+		}),
+	)
+	pool.Add(&addJob{})
+
+	go pool.Run(ctx)
+
+	for _, want := range []string{"a", "b"} {
+		mu.Lock()
+		tag = want
+		mu.Unlock()
+		pool.Reload()
+
+		if err := q.Put(&addJob{X: 1, Y: 2}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case got := <-tags:
+			if got != want {
+				t.Errorf("expecting middleware %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expecting middleware %q to run", want)
+		}
+	}
+}
+
+func TestPoolSignals(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloads := 0
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetSignalPolicy(worker.SignalPolicy{
+			Pause: []os.Signal{syscall.SIGUSR2},
+		}),
+		worker.SetReloadable(func(p *worker.Pool) { reloads++ }),
+	)
+	pool.Add(worker.WithContext(&sleepJob{}))
+
+	events := make(chan worker.EventKind, 10)
+	pool.Observe(worker.ObserverFunc(func(e worker.Event) { events <- e.Kind }))
+
+	// expect waits for an event of the given kind.
+	expect := func(kind worker.EventKind) {
+		t.Helper()
+		for {
+			select {
+			case k := <-events:
+				if k == kind {
+					return
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expecting %v event", kind)
+			}
+		}
+	}
+
+	pool.Reload()
+	if reloads != 2 {
+		t.Errorf("expecting %v reloads, got %v", 2, reloads)
+	}
+
+	go pool.Run(ctx)
+
+	// Wait until the pool is running.
+	if err := q.Put(worker.WithContext(&sleepJob{D: 1})); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	expect(worker.Paused)
+
+	if err := q.Put(worker.WithContext(&sleepJob{D: 2})); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-started:
+		t.Fatal("expecting paused pool")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	expect(worker.Resumed)
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("expecting resumed pool")
+	}
+}
+
+func TestPoolScale(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan worker.Event, 100)
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetWorkers(1),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+	pool.Add(worker.WithContext(&sleepJob{}))
+	pool.Observe(worker.ObserverFunc(func(e worker.Event) { events <- e }))
+
+	// expect waits for an event of the given kind.
+	expect := func(kind worker.EventKind) worker.Event {
+		t.Helper()
+		for {
+			select {
+			case e := <-events:
+				if e.Kind == kind {
+					return e
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expecting %v event", kind)
+			}
+		}
+	}
+
+	go pool.Run(ctx)
+	expect(worker.WorkerStarted)
+
+	if err := pool.Scale(3); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := expect(worker.Scaled); e.Workers != 3 {
+		t.Errorf("expecting %v workers, got %v", 3, e.Workers)
+	}
+
+	// The jobs run concurrently.
+	for i := 0; i < 3; i++ {
+		if err := q.Put(worker.WithContext(&sleepJob{D: 200})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(150 * time.Millisecond):
+			t.Fatalf("expecting %v jobs started, got %v", 3, i)
+		}
+	}
+
+	// Busy workers retire after their jobs complete.
+	if err := pool.Scale(1); err != nil {
+		t.Fatal(err)
+	}
+	expect(worker.WorkerRetired)
+	expect(worker.WorkerRetired)
+
+	pool.Pause()
+	expect(worker.Paused)
+
+	if !pool.Paused() {
+		t.Error("expecting paused pool")
+	}
+
+	pool.Resume()
+	expect(worker.Resumed)
+
+	if err := pool.Scale(0); err == nil {
+		t.Error("expecting bad workers count error")
+	}
+}
+
+var (
+	running    atomic.Int32
+	maxRunning atomic.Int32
+	finished   chan int = make(chan int, 10)
+)
+
+// limitJob represents a job tracking its concurrency.
+type limitJob struct{}
+
+func (j *limitJob) Make(args *worker.Args) (worker.Job, error) { return &limitJob{}, nil }
+
+func (j *limitJob) Run() error {
+	n := running.Add(1)
+	defer running.Add(-1)
+
+	for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	finished <- 1
+	return nil
+}
+
+func TestPoolMaxConcurrency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := worker.NewMemoryQueue()
+	pool := worker.NewPool(
+		worker.SetQueue(q),
+		worker.SetWorkers(4),
+		worker.SetSignalPolicy(worker.NoSignals),
+	)
+
+	err := pool.AddWithOptions(&limitJob{}, worker.MaxConcurrency(2), func(o *worker.FactoryOptions) {
+		o.DeferDelay = 10 * time.Millisecond
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 6; i++ {
+		if err := q.Put(&limitJob{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go pool.Run(ctx)
+
+	for i := 0; i < 6; i++ {
+		select {
+		case <-finished:
+		case <-time.After(time.Second):
+			t.Fatalf("expecting %v jobs finished, got %v", 6, i)
+		}
+	}
+
+	if n := maxRunning.Load(); n != 2 {
+		t.Errorf("expecting at most %v running jobs, got %v", 2, n)
+	}
 }